@@ -1,19 +1,17 @@
 package chclient
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -21,9 +19,10 @@ import (
 	"github.com/jpillora/chisel/share/ccrypto"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/metrics"
 	"github.com/jpillora/chisel/share/settings"
-	"github.com/jpillora/chisel/share/tunnel"
 	"github.com/jpillora/chisel/share/traffic"
+	"github.com/jpillora/chisel/share/tunnel"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/proxy"
@@ -34,6 +33,9 @@ import (
 type Config struct {
 	Fingerprint      string
 	Auth             string
+	ClientKey        string
+	ClientCert       string
+	HostCA           string
 	KeepAlive        time.Duration
 	MaxRetryCount    int
 	MaxRetryInterval time.Duration
@@ -44,6 +46,37 @@ type Config struct {
 	TLS              TLSConfig
 	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
 	Verbose          bool
+	Metrics          string
+	//Obfuscation profile, see traffic.ParseObfuscation
+	Obfuscation string
+	//Cover traffic setting, see traffic.ParseCoverTraffic
+	Cover string
+	//Control is the path of the control socket
+	Control string
+	//LogLevel is the minimum level logged, one of
+	//trace, debug, info, warn or error
+	LogLevel string
+	//LogFormat of the client log, text or json
+	LogFormat string
+	//Servers are tried after Server, in the
+	//order of the Strategy, see ServerConfig
+	Servers []ServerConfig
+	//Strategy of choosing the server to connect to, one of
+	//StrategyFailover (the default), StrategyRoundRobin
+	//or StrategyLatency
+	Strategy string
+	//ConnectTimeout bounds each attempt to connect to a server,
+	//its dial and handshake (default 30s, or 5s with several
+	//servers, so that dead ones are failed over quickly)
+	ConnectTimeout time.Duration
+	//Connections is the number of parallel SSH connections
+	//to the server, over which channels are spread (default 1,
+	//at most settings.MaxConnections)
+	Connections int
+	//Transport carrying the SSH connections, one of
+	//TransportAuto (the default), TransportWebsocket,
+	//TransportPoll or TransportH2
+	Transport string
 }
 
 // TLSConfig for a Client
@@ -58,40 +91,48 @@ type TLSConfig struct {
 // Client represents a client instance
 type Client struct {
 	*cio.Logger
-	config    *Config
-	computed  settings.Config
-	sshConfig *ssh.ClientConfig
-	tlsConfig *tls.Config
-	proxyURL  *url.URL
-	server    string
-	connCount cnet.ConnCount
-	stop      func()
-	eg        *errgroup.Group
-	tunnel    *tunnel.Tunnel
+	config        *Config
+	computed      settings.Config
+	sshConfig     *ssh.ClientConfig
+	hostCAs       []ssh.PublicKey
+	proxyURL      *url.URL
+	servers       []*server
+	current       *server
+	next          int
+	serversMut    sync.Mutex
+	connCount     cnet.ConnCount
+	stop          func()
+	eg            *errgroup.Group
+	tunnel        *tunnel.Tunnel
+	metrics       clientMetrics
+	metricsServer *cnet.HTTPServer
+	obfuscation   traffic.Obfuscation
+	cover         traffic.CoverTraffic
+	ctx           context.Context
+	started       time.Time
+	remotesMut    sync.Mutex
+	updateMut     sync.Mutex
+	//join is the token of the session of the primary
+	//connection, which extra connections join
+	join string
 }
 
 // NewClient creates a new client instance
 func NewClient(c *Config) (*Client, error) {
-	//apply default scheme
-	if !strings.HasPrefix(c.Server, "http") {
-		c.Server = "http://" + c.Server
-	}
 	if c.MaxRetryInterval < time.Second {
 		c.MaxRetryInterval = 5 * time.Minute
 	}
-	u, err := url.Parse(c.Server)
-	if err != nil {
-		return nil, err
+	if !validStrategy(c.Strategy) {
+		return nil, fmt.Errorf("Invalid strategy %q", c.Strategy)
 	}
-	//swap to websockets scheme
-	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
-	//apply default port
-	if !regexp.MustCompile(`:\d+$`).MatchString(u.Host) {
-		if u.Scheme == "wss" {
-			u.Host = u.Host + ":443"
-		} else {
-			u.Host = u.Host + ":80"
-		}
+	if !validTransport(c.Transport) {
+		return nil, fmt.Errorf("Invalid transport %q", c.Transport)
+	}
+	if c.Connections > settings.MaxConnections {
+		return nil, fmt.Errorf("Too many connections (max %d)", settings.MaxConnections)
+	}
+	if c.Headers == nil {
+		c.Headers = make(http.Header)
 	}
 	hasReverse := false
 	hasSocks := false
@@ -102,43 +143,45 @@ func NewClient(c *Config) (*Client, error) {
 		computed: settings.Config{
 			Version: chshare.BuildVersion,
 		},
-		server:    u.String(),
-		tlsConfig: nil,
 	}
 	//set default log level
 	client.Logger.Info = true
-	//configure tls
-	if u.Scheme == "wss" {
-		tc := &tls.Config{}
-		if c.TLS.ServerName != "" {
-			tc.ServerName = c.TLS.ServerName
+	if err := client.Configure(c.LogLevel, c.LogFormat); err != nil {
+		return nil, err
+	}
+	var err error
+	client.obfuscation, err = traffic.ParseObfuscation(c.Obfuscation)
+	if err != nil {
+		return nil, err
+	}
+	client.cover, err = traffic.ParseCoverTraffic(c.Cover)
+	if err != nil {
+		return nil, err
+	}
+	//servers, in order
+	servers := c.Servers
+	if c.Server != "" || len(servers) == 0 {
+		servers = append([]ServerConfig{{URL: c.Server}}, servers...)
+	}
+	for _, sc := range servers {
+		if sc.Fingerprint == "" {
+			sc.Fingerprint = c.Fingerprint
 		}
-		//certificate verification config
-		if c.TLS.SkipVerify {
-			client.Infof("TLS verification disabled")
-			tc.InsecureSkipVerify = true
-		} else if c.TLS.CA != "" {
-			rootCAs := x509.NewCertPool()
-			if b, err := os.ReadFile(c.TLS.CA); err != nil {
-				return nil, fmt.Errorf("Failed to load file: %s", c.TLS.CA)
-			} else if ok := rootCAs.AppendCertsFromPEM(b); !ok {
-				return nil, fmt.Errorf("Failed to decode PEM: %s", c.TLS.CA)
-			} else {
-				client.Infof("TLS verification using CA %s", c.TLS.CA)
-				tc.RootCAs = rootCAs
-			}
+		if sc.TLS == (TLSConfig{}) {
+			sc.TLS = c.TLS
 		}
-		//provide client cert and key pair for mtls
-		if c.TLS.Cert != "" && c.TLS.Key != "" {
-			c, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
-			if err != nil {
-				return nil, fmt.Errorf("Error loading client cert and key pair: %v", err)
-			}
-			tc.Certificates = []tls.Certificate{c}
-		} else if c.TLS.Cert != "" || c.TLS.Key != "" {
-			return nil, fmt.Errorf("Please specify client BOTH cert and key")
+		s, err := client.newServer(sc)
+		if err != nil {
+			return nil, err
+		}
+		client.servers = append(client.servers, s)
+	}
+	client.current = client.servers[0]
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = 30 * time.Second
+		if len(client.servers) > 1 {
+			c.ConnectTimeout = 5 * time.Second
 		}
-		client.tlsConfig = tc
 	}
 	//validate remotes
 	for _, s := range c.Remotes {
@@ -171,34 +214,75 @@ func NewClient(c *Config) (*Client, error) {
 			return nil, fmt.Errorf("Invalid proxy URL (%s)", err)
 		}
 	}
-	
+
 	// Generate realistic HTTP headers to mask traffic (default: enabled at highest level)
 	// Merge with user-provided headers (user headers take precedence)
 	// Note: WebSocket library automatically adds Connection header, so we remove it to avoid duplicates
-	if c.Headers == nil {
-		c.Headers = make(http.Header)
-	}
 	realisticHeaders := traffic.GenerateRealisticHeaders()
 	// Remove Connection header to avoid duplicate with WebSocket library
 	realisticHeaders.Del("Connection")
 	c.Headers = traffic.MergeHeaders(c.Headers, realisticHeaders)
 	//ssh auth and config with masked version string
 	user, pass := settings.ParseAuth(c.Auth)
+	auth := []ssh.AuthMethod{}
+	if c.ClientKey != "" {
+		signer, err := ccrypto.LoadKey(c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		//logged in the authorized_keys format of the server
+		client.Infof("Client key %s", bytes.TrimSpace(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		if c.ClientCert != "" {
+			cert, err := ccrypto.LoadCertificate(c.ClientCert)
+			if err != nil {
+				return nil, err
+			}
+			if signer, err = ssh.NewCertSigner(cert, signer); err != nil {
+				return nil, fmt.Errorf("Client certificate does not match the key (%s)", err)
+			}
+			//login as the first principal, unless set by --auth
+			if user == "" && len(cert.ValidPrincipals) > 0 {
+				user = cert.ValidPrincipals[0]
+			}
+			client.Infof("Client certificate %q for %s", cert.KeyId, strings.Join(cert.ValidPrincipals, ","))
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if c.ClientCert != "" {
+		return nil, errors.New("Client certificate requires a client key")
+	}
+	if c.HostCA != "" {
+		client.hostCAs, err = ccrypto.LoadPublicKeys(c.HostCA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	auth = append(auth, ssh.Password(pass))
 	client.sshConfig = &ssh.ClientConfig{
 		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+		Auth:            auth,
 		ClientVersion:   chshare.MaskedSSHClientVersion,
 		HostKeyCallback: client.verifyServer,
 		Timeout:         settings.EnvDuration("SSH_TIMEOUT", 30*time.Second),
 	}
+	//opt-in prometheus metrics
+	var registry *metrics.Registry
+	if c.Metrics != "" {
+		registry = metrics.NewRegistry()
+		client.metricsServer = cnet.NewHTTPServer()
+	}
+	client.metrics = newClientMetrics(registry)
 	//prepare client tunnel
 	client.tunnel = tunnel.New(tunnel.Config{
-		Logger:    client.Logger,
-		Inbound:   true, //client always accepts inbound
-		Outbound:  hasReverse,
-		Socks:     hasReverse && hasSocks,
-		KeepAlive: client.config.KeepAlive,
+		Logger:      client.Logger,
+		Inbound:     true, //client always accepts inbound
+		Outbound:    hasReverse,
+		Socks:       hasReverse && hasSocks,
+		KeepAlive:   client.config.KeepAlive,
+		Metrics:     client.metrics.tunnel,
+		Obfuscation: client.obfuscation,
+		Cover:       client.cover,
 	})
+	client.tunnel.MetricRemotes(client.computed.Remotes, nil)
 	return client, nil
 }
 
@@ -213,7 +297,20 @@ func (c *Client) Run() error {
 }
 
 func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	expect := c.config.Fingerprint
+	cert, isCert := key.(*ssh.Certificate)
+	if len(c.hostCAs) > 0 {
+		if isCert {
+			return c.verifyHostCert(hostname, remote, cert)
+		}
+		if c.currentServer().fingerprint == "" {
+			return errors.New("Server did not present a host certificate")
+		}
+	}
+	//fingerprints pin the key of host certificates
+	if isCert {
+		key = cert.Key
+	}
+	expect := c.currentServer().fingerprint
 	if expect == "" {
 		return nil
 	}
@@ -233,6 +330,26 @@ func (c *Client) verifyServer(hostname string, remote net.Addr, key ssh.PublicKe
 	return nil
 }
 
+// verifyHostCert checks the host certificate of the server
+// was issued for its host name by a trusted authority
+func (c *Client) verifyHostCert(hostname string, remote net.Addr, cert *ssh.Certificate) error {
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, ca := range c.hostCAs {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+	if err := checker.CheckHostKey(hostname, remote, cert); err != nil {
+		return fmt.Errorf("Invalid host certificate (%s)", strings.TrimPrefix(err.Error(), "ssh: "))
+	}
+	c.Infof("Host certificate %q", cert.KeyId)
+	return nil
+}
+
 // verifyLegacyFingerprint calculates and compares legacy MD5 fingerprints
 func (c *Client) verifyLegacyFingerprint(key ssh.PublicKey) error {
 	bytes := md5.Sum(key.Marshal())
@@ -241,7 +358,7 @@ func (c *Client) verifyLegacyFingerprint(key ssh.PublicKey) error {
 		strbytes[i] = fmt.Sprintf("%02x", b)
 	}
 	got := strings.Join(strbytes, ":")
-	expect := c.config.Fingerprint
+	expect := c.currentServer().fingerprint
 	if !strings.HasPrefix(got, expect) {
 		return fmt.Errorf("Invalid fingerprint (%s)", got)
 	}
@@ -254,34 +371,60 @@ func (c *Client) Start(ctx context.Context) error {
 	c.stop = cancel
 	eg, ctx := errgroup.WithContext(ctx)
 	c.eg = eg
+	c.remotesMut.Lock()
+	c.ctx = ctx
+	c.started = time.Now()
+	c.remotesMut.Unlock()
 	via := ""
 	if c.proxyURL != nil {
 		via = " via " + c.proxyURL.String()
 	}
-	c.Infof("Connecting to %s%s\n", c.server, via)
+	urls := []string{}
+	for _, s := range c.servers {
+		urls = append(urls, s.url)
+	}
+	c.Infof("Connecting to %s%s", strings.Join(urls, ", "), via)
+	if c.metricsServer != nil {
+		if err := c.startMetrics(ctx); err != nil {
+			return err
+		}
+	}
+	if c.config.Control != "" {
+		if err := c.startControl(ctx); err != nil {
+			return err
+		}
+	}
 	//connect to chisel server
 	eg.Go(func() error {
 		return c.connectionLoop(ctx)
 	})
+	for i := 1; i < c.config.Connections; i++ {
+		n := i
+		eg.Go(func() error {
+			return c.extraLoop(ctx, n)
+		})
+	}
 	//listen sockets
+	c.remotesMut.Lock()
+	clientInbound := c.computed.Remotes.Reversed(false)
+	c.remotesMut.Unlock()
 	eg.Go(func() error {
-		clientInbound := c.computed.Remotes.Reversed(false)
 		if len(clientInbound) == 0 {
 			return nil
 		}
 		return c.tunnel.BindRemotes(ctx, clientInbound)
 	})
-	//bind reverse remotes
-	eg.Go(func() error {
-		clientReverse := c.computed.Remotes.Reversed(true)
-		if len(clientReverse) == 0 {
-			return nil
-		}
-		return c.tunnel.BindRemotes(ctx, clientReverse)
-	})
 	return nil
 }
 
+// DialContext connects to the address on the named network (tcp or udp)
+// through the server, without binding a local port. The server's user
+// access rules apply to addr as they do to remotes. It blocks while the
+// client is (re)connecting.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return c.tunnel.DialContext(ctx, network, addr)
+}
+
 func (c *Client) setProxy(u *url.URL, d *websocket.Dialer) error {
 	// CONNECT proxy
 	if !strings.HasPrefix(u.Scheme, "socks") {
@@ -291,8 +434,33 @@ func (c *Client) setProxy(u *url.URL, d *websocket.Dialer) error {
 		return nil
 	}
 	// SOCKS5 proxy
+	socksDialer, err := socksProxy(u)
+	if err != nil {
+		return err
+	}
+	d.NetDial = socksDialer.Dial
+	return nil
+}
+
+// setTransportProxy is setProxy for the http transport
+// of the polling transport
+func (c *Client) setTransportProxy(u *url.URL, t *http.Transport) error {
+	if !strings.HasPrefix(u.Scheme, "socks") {
+		t.Proxy = http.ProxyURL(u)
+		return nil
+	}
+	socksDialer, err := socksProxy(u)
+	if err != nil {
+		return err
+	}
+	t.DialContext = socksDialer.(proxy.ContextDialer).DialContext
+	return nil
+}
+
+// socksProxy returns a dialer through the SOCKS5 proxy
+func socksProxy(u *url.URL) (proxy.Dialer, error) {
 	if u.Scheme != "socks" && u.Scheme != "socks5h" {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"unsupported socks proxy type: %s:// (only socks5h:// or socks:// is supported)",
 			u.Scheme,
 		)
@@ -305,12 +473,7 @@ func (c *Client) setProxy(u *url.URL, d *websocket.Dialer) error {
 			Password: pass,
 		}
 	}
-	socksDialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
-	if err != nil {
-		return err
-	}
-	d.NetDial = socksDialer.Dial
-	return nil
+	return proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
 }
 
 // Wait blocks while the client is running.
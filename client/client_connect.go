@@ -8,12 +8,9 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
-	chshare "github.com/jpillora/chisel/share"
-	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cos"
-	"github.com/jpillora/chisel/share/settings"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -25,7 +22,7 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 	var adaptiveBackoff time.Duration
 	
 	for {
-		connected, err := c.connectionOnce(ctx)
+		connected, err := c.connectServers(ctx)
 		//reset backoff after successful connections
 		if connected {
 			b.Reset()
@@ -53,7 +50,7 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 				}
 				msg += fmt.Sprintf(" (Attempt: %d/%s)", attempt, maxAttemptVal)
 			}
-			c.Infof(msg)
+			c.Infof("%s", msg)
 		}
 		//give up?
 		if maxAttempt >= 0 && attempt >= maxAttempt {
@@ -83,6 +80,7 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 			finalBackoff = 10 * time.Minute
 		}
 		
+		c.metrics.reconnects.Inc()
 		c.Infof("Retrying in %s (adaptive: %s)...", finalBackoff, adaptiveBackoff)
 		select {
 		case <-cos.AfterSignal(finalBackoff):
@@ -96,8 +94,44 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 	return nil
 }
 
+// extraLoop maintains an extra connection to the server of
+// the primary connection, replacing it whenever it is lost
+func (c *Client) extraLoop(ctx context.Context, n int) error {
+	l := c.ForkID("ssh", n+1)
+	b := &backoff.Backoff{Max: c.config.MaxRetryInterval}
+	for {
+		//extra connections follow the primary one
+		if !c.tunnel.WaitPrimary(ctx) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		connected, err := c.connect(ctx, l, c.currentServer(), false)
+		if connected {
+			b.Reset()
+		}
+		if err != nil && err != io.EOF && !strings.HasSuffix(err.Error(), "use of closed network connection") {
+			l.Infof("Connection error: %s", err)
+		}
+		select {
+		case <-time.After(b.Duration()):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 // connectionOnce connects to the chisel server and blocks
-func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error) {
+func (c *Client) connectionOnce(ctx context.Context, s *server) (connected bool, err error) {
+	return c.connect(ctx, c.Logger, s, true)
+}
+
+var errConnectTimeout = errors.New("Connect timeout")
+
+// connect makes the primary or an extra connection to the
+// server, and blocks while connected
+func (c *Client) connect(ctx context.Context, l *cio.Logger, s *server, primary bool) (connected bool, err error) {
 	//already closed?
 	select {
 	case <-ctx.Done():
@@ -107,77 +141,77 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	//prepare dialer
-	// Use masked protocol to hide chisel identity
-	// The actual protocol verification happens via SSH custom request after handshake
-	d := websocket.Dialer{
-		HandshakeTimeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second),
-		Subprotocols:     []string{chshare.MaskedWebSocketProtocol},
-		TLSClientConfig:  c.tlsConfig,
-		ReadBufferSize:   settings.EnvInt("WS_BUFF_SIZE", 0),
-		WriteBufferSize:  settings.EnvInt("WS_BUFF_SIZE", 0),
-		NetDialContext:   c.config.DialContext,
-	}
-	//optional proxy
-	if p := c.proxyURL; p != nil {
-		if err := c.setProxy(p, &d); err != nil {
-			return false, err
-		}
-	}
-	
-	// Connection timeout with adaptive strategy
-	connectCtx, connectCancel := context.WithTimeout(ctx, 30*time.Second)
+	// the dial and handshake are bounded by the connect timeout,
+	// which closes the connection when exceeded
+	connectCtx, connectCancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer connectCancel()
-	
-	// Remove Connection header to avoid duplicate with WebSocket library
-	headers := c.config.Headers.Clone()
-	if headers != nil {
-		headers.Del("Connection")
-	}
-	
-	wsConn, _, err := d.DialContext(connectCtx, c.server, headers)
+	conn, err := c.dial(connectCtx, l, s)
 	if err != nil {
-		// Check for specific error types to adjust strategy
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline") {
-			c.Debugf("Connection timeout, network may be unstable")
-		}
 		return false, err
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
+	stopTimeout := context.AfterFunc(connectCtx, func() {
+		conn.Close()
+	})
 	// perform SSH handshake on net.Conn
-	c.Debugf("Handshaking...")
-	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
+	l.Debugf("Handshaking...")
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.sshAddr, c.sshConfig)
+	if err != nil && errors.Is(connectCtx.Err(), context.DeadlineExceeded) {
+		err = errConnectTimeout
+	}
 	if err != nil {
 		e := err.Error()
 		if strings.Contains(e, "unable to authenticate") {
-			c.Infof("Authentication failed")
-			c.Debugf(e)
+			l.Warnf("Authentication failed")
+			l.Debugf("%s", e)
 		} else {
-			c.Infof(e)
+			l.Infof("%s", e)
 		}
 		return false, err
 	}
 	defer sshConn.Close()
 	// chisel client handshake (reverse of server handshake)
 	// send configuration
-	c.Debugf("Sending config")
+	l.Debugf("Sending config")
 	t0 := time.Now()
-	_, configerr, err := sshConn.SendRequest(
+	ok, reply, err := sshConn.SendRequest(
 		"config",
 		true,
-		settings.EncodeConfig(c.computed),
+		c.encodedConfig(primary),
 	)
+	if !stopTimeout() {
+		err = errConnectTimeout
+	}
 	if err != nil {
-		c.Infof("Config verification failed")
+		l.Warnf("Config verification failed")
 		return false, err
 	}
-	if len(configerr) > 0 {
-		return false, errors.New(string(configerr))
+	if !ok {
+		return false, errors.New(string(reply))
+	}
+	if primary {
+		c.remotesMut.Lock()
+		c.join = string(reply)
+		c.remotesMut.Unlock()
+	}
+	latency := time.Since(t0)
+	if !primary {
+		//extra connections only log in debug
+		l.Debugf("Connected (Latency %s)", latency)
+		err = c.tunnel.BindExtraSSH(ctx, sshConn, reqs, chans)
+		l.Debugf("Disconnected")
+		return time.Since(t0) > 5*time.Second, err
+	}
+	c.measured(s, latency)
+	if len(c.servers) > 1 {
+		l.Infof("Connected to %s (Latency %s)", s.url, latency)
+	} else {
+		l.Infof("Connected (Latency %s)", latency)
 	}
-	c.Infof("Connected (Latency %s)", time.Since(t0))
 	//connected, handover ssh connection for tunnel to use, and block
+	c.metrics.connected.Set(1)
 	err = c.tunnel.BindSSH(ctx, sshConn, reqs, chans)
-	c.Infof("Disconnected")
+	c.metrics.connected.Set(0)
+	l.Infof("Disconnected")
 	connected = time.Since(t0) > 5*time.Second
 	return connected, err
 }
@@ -0,0 +1,83 @@
+package chclient
+
+import (
+	"context"
+	"time"
+
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/control"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// Status describes the state of a running client
+type Status struct {
+	Role    string    `json:"role"`
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	Server  string    `json:"server"`
+	//Servers are listed when there is more than one
+	Servers   []ServerStatus `json:"servers,omitempty"`
+	Connected bool           `json:"connected"`
+	//SSHConnections are the connections to the server,
+	//when there are parallel connections
+	SSHConnections int `json:"sshConnections,omitempty"`
+	//LatencyMs is the round trip of the last keepalive
+	//ping, sent at LastPing (omitted until the first)
+	LatencyMs float64    `json:"latencyMs,omitempty"`
+	LastPing  *time.Time `json:"lastPing,omitempty"`
+	Remotes   []string   `json:"remotes"`
+	//Listeners are the local listeners of forward remotes,
+	//reverse remotes listen on the server
+	Listeners   []tunnel.ProxyStatus   `json:"listeners"`
+	Connections tunnel.ConnectionStats `json:"connections"`
+	Runtime     control.Runtime        `json:"runtime"`
+}
+
+// Status returns the current state of the client
+func (c *Client) Status() Status {
+	c.remotesMut.Lock()
+	st := Status{
+		Role:      "client",
+		Version:   chshare.BuildVersion,
+		Started:   c.started,
+		Server:    c.currentServer().url,
+		Servers:   c.serverStatus(),
+		Connected: c.tunnel.Connected(),
+		Remotes:   []string{},
+		Listeners: c.tunnel.Proxies(),
+		Runtime:   control.ReadRuntime(),
+	}
+	for _, r := range c.computed.Remotes {
+		st.Remotes = append(st.Remotes, r.String())
+	}
+	if c.config.Connections > 1 {
+		st.SSHConnections = c.tunnel.Connections()
+	}
+	c.remotesMut.Unlock()
+	if latency, at := c.tunnel.LastPing(); !at.IsZero() {
+		st.LatencyMs = float64(latency) / float64(time.Millisecond)
+		st.LastPing = &at
+	}
+	st.Connections = tunnel.TotalStats(st.Listeners)
+	return st
+}
+
+// startControl serves the control socket
+func (c *Client) startControl(ctx context.Context) error {
+	l, err := control.Listen(c.config.Control)
+	if err != nil {
+		return c.Errorf("control: %s", err)
+	}
+	c.Infof("Control socket listening on %s", c.config.Control)
+	//serve within the errgroup, so that
+	//Wait returns once the socket is removed
+	c.eg.Go(func() error {
+		control.Serve(ctx, l, control.Handlers{
+			"status": func() (interface{}, error) {
+				return c.Status(), nil
+			},
+		})
+		return nil
+	})
+	return nil
+}
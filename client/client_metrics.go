@@ -0,0 +1,37 @@
+package chclient
+
+import (
+	"context"
+
+	"github.com/jpillora/chisel/share/metrics"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// clientMetrics are no-ops unless metrics are enabled
+type clientMetrics struct {
+	registry   *metrics.Registry
+	connected  *metrics.Value
+	reconnects *metrics.Value
+	tunnel     *tunnel.Metrics
+}
+
+func newClientMetrics(r *metrics.Registry) clientMetrics {
+	m := clientMetrics{
+		registry:   r,
+		connected:  r.Gauge("chisel_connected", "Whether the client is connected to the server.").With(),
+		reconnects: r.Counter("chisel_reconnect_attempts_total", "Attempts to reconnect to the server.").With(),
+	}
+	if r != nil {
+		m.tunnel = tunnel.NewMetrics(r)
+	}
+	return m
+}
+
+// startMetrics serves the metrics on their own listener
+func (c *Client) startMetrics(ctx context.Context) error {
+	if err := c.metricsServer.GoListenAndServeContext(ctx, c.config.Metrics, c.metrics.registry); err != nil {
+		return c.Errorf("metrics: %s", err)
+	}
+	c.Infof("Metrics listening on http://%s/metrics", c.config.Metrics)
+	return nil
+}
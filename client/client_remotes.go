@@ -0,0 +1,184 @@
+package chclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// AddRemote adds a remote to the running client, without
+// reconnecting. The server validates the remote, and binds
+// it if reversed, before the client binds non-reversed remotes.
+// Blocks while the client is (re)connecting.
+func (c *Client) AddRemote(ctx context.Context, remote string) error {
+	r, err := settings.DecodeRemote(remote)
+	if err != nil {
+		return fmt.Errorf("Failed to decode remote '%s': %s", remote, err)
+	}
+	if r.Stdio {
+		return errors.New("stdio remotes cannot be added")
+	}
+	runCtx, err := c.running()
+	if err != nil {
+		return err
+	}
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	if c.findRemote(r) >= 0 {
+		return fmt.Errorf("Remote %s already exists", r)
+	}
+	if !r.Reverse && !r.CanListen() {
+		return fmt.Errorf("Client cannot listen on %s", r)
+	}
+	//the server may open channels as soon as it binds the
+	//remote, so outbound is enabled first, and undone if the
+	//server rejects the remote
+	if r.Reverse {
+		restore := c.tunnel.EnableOutbound(r.Socks)
+		if err := c.updateRemotes(ctx, settings.RemotesUpdate{Add: settings.Remotes{r}}); err != nil {
+			restore()
+			return err
+		}
+	} else if err := c.updateRemotes(ctx, settings.RemotesUpdate{Add: settings.Remotes{r}}); err != nil {
+		return err
+	}
+	if !r.Reverse {
+		if err := c.tunnel.AddRemote(runCtx, r); err != nil {
+			//undo on the server
+			c.updateRemotes(ctx, settings.RemotesUpdate{Remove: settings.Remotes{r}})
+			return err
+		}
+	}
+	c.remotesMut.Lock()
+	c.computed.Remotes = append(c.computed.Remotes, r)
+	c.remotesMut.Unlock()
+	c.tunnel.MetricRemotes(settings.Remotes{r}, nil)
+	return nil
+}
+
+// RemoveRemote removes a remote from the running client, without
+// reconnecting. Connections of other remotes are unaffected, as are
+// the connections which were already established through it.
+// Blocks while the client is (re)connecting.
+func (c *Client) RemoveRemote(ctx context.Context, remote string) error {
+	r, err := settings.DecodeRemote(remote)
+	if err != nil {
+		return fmt.Errorf("Failed to decode remote '%s': %s", remote, err)
+	}
+	if _, err := c.running(); err != nil {
+		return err
+	}
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	i := c.findRemote(r)
+	if i < 0 {
+		return fmt.Errorf("Remote %s not found", r)
+	}
+	if r.Stdio {
+		return errors.New("stdio remotes cannot be removed")
+	}
+	if err := c.updateRemotes(ctx, settings.RemotesUpdate{Remove: settings.Remotes{r}}); err != nil {
+		return err
+	}
+	if !r.Reverse {
+		if err := c.tunnel.RemoveRemote(r); err != nil {
+			return err
+		}
+	}
+	c.remotesMut.Lock()
+	rs := c.computed.Remotes
+	c.computed.Remotes = append(rs[:i:i], rs[i+1:]...)
+	c.remotesMut.Unlock()
+	c.tunnel.MetricRemotes(nil, settings.Remotes{r})
+	return nil
+}
+
+// SetRemoteLimit changes the rate limit of a remote of the
+// running client, in bytes per second (0 is unlimited). The
+// limit applies to established connections, and the limits
+// of reversed remotes are changed by the server.
+// Blocks while the client is (re)connecting.
+func (c *Client) SetRemoteLimit(ctx context.Context, remote string, rate int64) error {
+	r, err := settings.DecodeRemote(remote)
+	if err != nil {
+		return fmt.Errorf("Failed to decode remote '%s': %s", remote, err)
+	}
+	if rate < 0 {
+		return errors.New("rate must not be negative")
+	}
+	if _, err := c.running(); err != nil {
+		return err
+	}
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	i := c.findRemote(r)
+	if i < 0 {
+		return fmt.Errorf("Remote %s not found", r)
+	}
+	c.remotesMut.Lock()
+	limited := *c.computed.Remotes[i]
+	c.remotesMut.Unlock()
+	limited.Rate = rate
+	if r.Reverse {
+		err = c.updateRemotes(ctx, settings.RemotesUpdate{Limit: settings.Remotes{&limited}})
+	} else {
+		err = c.tunnel.SetRemoteLimit(&limited)
+	}
+	if err != nil {
+		return err
+	}
+	//reconnects keep the limit
+	c.remotesMut.Lock()
+	c.computed.Remotes[i] = &limited
+	c.remotesMut.Unlock()
+	return nil
+}
+
+// updateRemotes requests the server to apply the update
+func (c *Client) updateRemotes(ctx context.Context, u settings.RemotesUpdate) error {
+	if err := c.tunnel.Request(ctx, "remotes", settings.EncodeRemotesUpdate(u)); err != nil {
+		return fmt.Errorf("Server rejected remotes update: %s", err)
+	}
+	return nil
+}
+
+// findRemote returns the index of the given remote, or -1
+func (c *Client) findRemote(r *settings.Remote) int {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	for i, other := range c.computed.Remotes {
+		if other.Encode() == r.Encode() {
+			return i
+		}
+	}
+	return -1
+}
+
+// running returns the context of the started client
+func (c *Client) running() (context.Context, error) {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	if c.ctx == nil {
+		return nil, errors.New("Client not started")
+	}
+	return c.ctx, nil
+}
+
+// encodedConfig returns the config sent to the server on connect,
+// extra connections only carry the forward remotes, so that reverse
+// remotes are bound once, by the primary connection
+func (c *Client) encodedConfig(primary bool) []byte {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	if !primary {
+		//extra connections join the session of the primary one
+		return settings.EncodeConfig(settings.Config{Version: c.computed.Version, Join: c.join})
+	}
+	config := c.computed
+	if c.config.Connections > 1 {
+		config.Connections = c.config.Connections
+	}
+	return settings.EncodeConfig(config)
+}
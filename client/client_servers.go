@@ -0,0 +1,229 @@
+package chclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Strategies of choosing the server to connect to
+const (
+	// StrategyFailover tries the servers in order, starting
+	// again with the first one after each disconnection
+	StrategyFailover = "failover"
+	// StrategyRoundRobin starts each round of connection
+	// attempts with the server after the previous one
+	StrategyRoundRobin = "round-robin"
+	// StrategyLatency prefers the server with the lowest
+	// latency, measured by the handshake of the client
+	StrategyLatency = "latency"
+)
+
+// ServerConfig is a server the client may connect to, in
+// addition to Config.Server. An empty Fingerprint or TLS
+// defaults to that of the Config.
+type ServerConfig struct {
+	URL         string
+	Fingerprint string
+	TLS         TLSConfig
+}
+
+// ServerStatus describes a server of the client
+type ServerStatus struct {
+	URL string `json:"url"`
+	// LatencyMs is the latency of the last handshake,
+	// omitted until the client connects to the server
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	Failed    bool    `json:"failed"`
+	Active    bool    `json:"active"`
+}
+
+// server is a server of the client and its state
+type server struct {
+	url         string
+	fingerprint string
+	sshAddr     string
+	tlsConfig   *tls.Config
+	h2          *http2.Transport
+	latency     time.Duration
+	failed      bool
+}
+
+// newServer parses the URL of a server and prepares its TLS config
+func (c *Client) newServer(sc ServerConfig) (*server, error) {
+	//apply default scheme, raw servers keep theirs
+	raw := strings.HasPrefix(sc.URL, "tcp://") || strings.HasPrefix(sc.URL, "tls://")
+	if !raw && !strings.HasPrefix(sc.URL, "http") {
+		sc.URL = "http://" + sc.URL
+	}
+	u, err := url.Parse(sc.URL)
+	if err != nil {
+		return nil, err
+	}
+	//swap to websockets scheme
+	if !raw {
+		u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	}
+	//apply default port
+	if !regexp.MustCompile(`:\d+$`).MatchString(u.Host) {
+		if raw {
+			return nil, fmt.Errorf("Missing port in server URL %q", sc.URL)
+		}
+		if u.Scheme == "wss" {
+			u.Host = u.Host + ":443"
+		} else {
+			u.Host = u.Host + ":80"
+		}
+	}
+	s := &server{url: u.String(), fingerprint: sc.Fingerprint}
+	//configure tls
+	if u.Scheme == "wss" || u.Scheme == "tls" {
+		if s.tlsConfig, err = c.newTLSConfig(sc.TLS); err != nil {
+			return nil, err
+		}
+	}
+	//host certificates are checked against the name of the server
+	s.sshAddr = u.Host
+	if h := c.config.Headers.Get("Host"); h != "" {
+		if host, _, err := net.SplitHostPort(h); err == nil {
+			h = host
+		}
+		s.sshAddr = net.JoinHostPort(h, u.Port())
+	}
+	return s, nil
+}
+
+func (c *Client) newTLSConfig(t TLSConfig) (*tls.Config, error) {
+	tc := &tls.Config{}
+	if t.ServerName != "" {
+		tc.ServerName = t.ServerName
+	}
+	//certificate verification config
+	if t.SkipVerify {
+		c.Infof("TLS verification disabled")
+		tc.InsecureSkipVerify = true
+	} else if t.CA != "" {
+		rootCAs := x509.NewCertPool()
+		if b, err := os.ReadFile(t.CA); err != nil {
+			return nil, fmt.Errorf("Failed to load file: %s", t.CA)
+		} else if ok := rootCAs.AppendCertsFromPEM(b); !ok {
+			return nil, fmt.Errorf("Failed to decode PEM: %s", t.CA)
+		} else {
+			c.Infof("TLS verification using CA %s", t.CA)
+			tc.RootCAs = rootCAs
+		}
+	}
+	//provide client cert and key pair for mtls
+	if t.Cert != "" && t.Key != "" {
+		c, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client cert and key pair: %v", err)
+		}
+		tc.Certificates = []tls.Certificate{c}
+	} else if t.Cert != "" || t.Key != "" {
+		return nil, fmt.Errorf("Please specify client BOTH cert and key")
+	}
+	return tc, nil
+}
+
+// connectServers makes a round of connection attempts, trying
+// each server once in the order of the strategy, until one of
+// them stays connected or the context is cancelled
+func (c *Client) connectServers(ctx context.Context) (connected bool, err error) {
+	servers := c.order()
+	for i, s := range servers {
+		c.serversMut.Lock()
+		c.current = s
+		c.serversMut.Unlock()
+		connected, err = c.connectionOnce(ctx, s)
+		c.serversMut.Lock()
+		s.failed = !connected && err != nil && err != io.EOF
+		c.serversMut.Unlock()
+		if connected || ctx.Err() != nil {
+			return connected, err
+		}
+		if i < len(servers)-1 && err != nil && err != io.EOF {
+			c.Infof("Connection error: %s (%s)", err, s.url)
+		}
+	}
+	return false, err
+}
+
+// order returns the servers in the order of the strategy
+func (c *Client) order() []*server {
+	c.serversMut.Lock()
+	defer c.serversMut.Unlock()
+	servers := append([]*server{}, c.servers...)
+	switch c.config.Strategy {
+	case StrategyRoundRobin:
+		n := c.next % len(servers)
+		c.next++
+		servers = append(servers[n:], servers[:n]...)
+	case StrategyLatency:
+		//servers which failed go last, while those
+		//never connected to go first to be measured
+		sort.SliceStable(servers, func(i, j int) bool {
+			a, b := servers[i], servers[j]
+			if a.failed != b.failed {
+				return b.failed
+			}
+			return a.latency < b.latency
+		})
+	}
+	return servers
+}
+
+// measured records the handshake latency of a server
+func (c *Client) measured(s *server, latency time.Duration) {
+	c.serversMut.Lock()
+	s.latency = latency
+	c.serversMut.Unlock()
+}
+
+// currentServer returns the server being connected
+// to, or the last one connected to
+func (c *Client) currentServer() *server {
+	c.serversMut.Lock()
+	defer c.serversMut.Unlock()
+	return c.current
+}
+
+// serverStatus lists the servers of the client, when
+// there is more than one
+func (c *Client) serverStatus() []ServerStatus {
+	c.serversMut.Lock()
+	defer c.serversMut.Unlock()
+	if len(c.servers) < 2 {
+		return nil
+	}
+	connected := c.tunnel.Connected()
+	out := []ServerStatus{}
+	for _, s := range c.servers {
+		out = append(out, ServerStatus{
+			URL:       s.url,
+			LatencyMs: float64(s.latency) / float64(time.Millisecond),
+			Failed:    s.failed,
+			Active:    connected && s == c.current,
+		})
+	}
+	return out
+}
+
+func validStrategy(s string) bool {
+	switch s {
+	case "", StrategyFailover, StrategyRoundRobin, StrategyLatency:
+		return true
+	}
+	return false
+}
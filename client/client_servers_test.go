@@ -0,0 +1,58 @@
+package chclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerOrder(t *testing.T) {
+	config := Config{
+		Server: "a",
+		Servers: []ServerConfig{
+			{URL: "b"},
+			{URL: "https://c"},
+		},
+	}
+	c, err := NewClient(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := func() []string {
+		urls := []string{}
+		for _, s := range c.order() {
+			urls = append(urls, s.url)
+		}
+		return urls
+	}
+	a, b, cc := "ws://a:80", "ws://b:80", "wss://c:443"
+	for _, tc := range []struct {
+		strategy string
+		expect   [][]string
+	}{
+		{StrategyFailover, [][]string{{a, b, cc}, {a, b, cc}}},
+		{StrategyRoundRobin, [][]string{{a, b, cc}, {b, cc, a}, {cc, a, b}}},
+	} {
+		config.Strategy = tc.strategy
+		for _, expect := range tc.expect {
+			if got := order(); !reflect.DeepEqual(got, expect) {
+				t.Fatalf("%s: expected %v, got %v", tc.strategy, expect, got)
+			}
+		}
+	}
+	//servers which were not measured go first, failed ones last
+	config.Strategy = StrategyLatency
+	c.servers[0].latency = 30 * time.Millisecond
+	c.servers[1].failed = true
+	c.servers[2].latency = 20 * time.Millisecond
+	if got, expect := order(), []string{cc, a, b}; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+	c.servers[1].failed = false
+	if got, expect := order(), []string{b, cc, a}; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+	if _, err := NewClient(&Config{Server: "a", Strategy: "random"}); err == nil {
+		t.Fatal("expected an invalid strategy")
+	}
+}
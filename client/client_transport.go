@@ -0,0 +1,226 @@
+package chclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
+)
+
+// Transports carrying the SSH connections to the server
+const (
+	// TransportAuto uses websockets, falling back to HTTP
+	// polling when the upgrade is refused, as happens with
+	// proxies which strip the Upgrade header
+	TransportAuto = "auto"
+	// TransportWebsocket only uses websockets
+	TransportWebsocket = "websocket"
+	// TransportPoll carries the connection over plain HTTP
+	// requests, long-polling the server for its data
+	TransportPoll = "poll"
+	// TransportH2 carries the connection over an HTTP/2
+	// stream, those to a server share a TCP connection
+	TransportH2 = "h2"
+)
+
+// dial connects to the server over the configured transport,
+// or directly to servers with tcp:// and tls:// URLs
+func (c *Client) dial(ctx context.Context, l *cio.Logger, s *server) (net.Conn, error) {
+	if u, err := url.Parse(s.url); err == nil && (u.Scheme == "tcp" || u.Scheme == "tls") {
+		return c.obfuscate(c.dialRaw(ctx, u.Host, s))
+	}
+	switch c.config.Transport {
+	case TransportPoll:
+		return c.obfuscate(c.dialPoll(ctx, s))
+	case TransportWebsocket:
+		return c.dialWebsocket(ctx, l, s)
+	case TransportH2:
+		return c.obfuscate(c.dialH2(ctx, s))
+	}
+	conn, err := c.dialWebsocket(ctx, l, s)
+	if errors.Is(err, websocket.ErrBadHandshake) {
+		l.Debugf("Websocket upgrade refused, falling back to HTTP polling")
+		return c.obfuscate(c.dialPoll(ctx, s))
+	}
+	return conn, err
+}
+
+// obfuscate delays the writes of the conns of transports other
+// than websockets, which are delayed by cnet.NewWebSocketConnWith
+func (c *Client) obfuscate(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		return nil, err
+	}
+	return cnet.NewObfuscatedConn(conn, c.obfuscation), nil
+}
+
+// dialWebsocket connects to the server over a websocket
+func (c *Client) dialWebsocket(ctx context.Context, l *cio.Logger, s *server) (net.Conn, error) {
+	//prepare dialer
+	// Use masked protocol to hide chisel identity
+	// The actual protocol verification happens via SSH custom request after handshake
+	d := websocket.Dialer{
+		HandshakeTimeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second),
+		Subprotocols:     []string{chshare.MaskedWebSocketProtocol},
+		TLSClientConfig:  s.tlsConfig,
+		ReadBufferSize:   settings.EnvInt("WS_BUFF_SIZE", 0),
+		WriteBufferSize:  settings.EnvInt("WS_BUFF_SIZE", 0),
+		NetDialContext:   c.config.DialContext,
+	}
+	//optional proxy
+	if p := c.proxyURL; p != nil {
+		if err := c.setProxy(p, &d); err != nil {
+			return nil, err
+		}
+	}
+	// Remove Connection header to avoid duplicate with WebSocket library
+	headers := c.config.Headers.Clone()
+	if headers != nil {
+		headers.Del("Connection")
+	}
+	wsConn, _, err := d.DialContext(ctx, s.url, headers)
+	if err != nil {
+		// Check for specific error types to adjust strategy
+		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline") {
+			l.Debugf("Connection timeout, network may be unstable")
+		}
+		return nil, err
+	}
+	return cnet.NewWebSocketConnWith(wsConn, c.obfuscation), nil
+}
+
+// dialPoll connects to the server over HTTP polling
+func (c *Client) dialPoll(ctx context.Context, s *server) (net.Conn, error) {
+	t := &http.Transport{
+		TLSClientConfig:     s.tlsConfig,
+		DialContext:         c.config.DialContext,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if p := c.proxyURL; p != nil {
+		if err := c.setTransportProxy(p, t); err != nil {
+			return nil, err
+		}
+	}
+	//polls use the http scheme of the server
+	httpURL := strings.Replace(s.url, "ws", "http", 1)
+	headers := c.config.Headers.Clone()
+	headers.Del("Connection")
+	return cnet.DialPoll(ctx, &http.Client{Transport: t}, httpURL, headers)
+}
+
+// dialH2 connects to the server over an HTTP/2 stream
+func (c *Client) dialH2(ctx context.Context, s *server) (net.Conn, error) {
+	t, err := c.h2Transport(s)
+	if err != nil {
+		return nil, err
+	}
+	httpURL := strings.Replace(s.url, "ws", "http", 1)
+	headers := c.config.Headers.Clone()
+	headers.Del("Connection")
+	return cnet.DialStream(ctx, t, httpURL, headers)
+}
+
+// h2Transport returns the HTTP/2 transport of the server,
+// which multiplexes its streams over one connection
+func (c *Client) h2Transport(s *server) (*http2.Transport, error) {
+	c.serversMut.Lock()
+	defer c.serversMut.Unlock()
+	if s.h2 != nil {
+		return s.h2, nil
+	}
+	dial, err := c.dialer("the h2 transport")
+	if err != nil {
+		return nil, err
+	}
+	secure := s.tlsConfig != nil
+	t := &http2.Transport{
+		//h2c, with prior knowledge, unless the server uses tls
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, tc *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil || !secure {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, tc)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
+				conn.Close()
+				return nil, errors.New("HTTP/2 not supported by server")
+			}
+			return tlsConn, nil
+		},
+	}
+	if secure {
+		t.TLSClientConfig = s.tlsConfig.Clone()
+		t.TLSClientConfig.NextProtos = []string{"h2"}
+	}
+	s.h2 = t
+	return t, nil
+}
+
+// dialRaw connects to a tcp:// or tls:// server, over
+// which SSH runs without HTTP
+func (c *Client) dialRaw(ctx context.Context, addr string, s *server) (net.Conn, error) {
+	dial, err := c.dialer("tcp:// and tls:// servers")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil || s.tlsConfig == nil {
+		return conn, err
+	}
+	tc := s.tlsConfig.Clone()
+	if tc.ServerName == "" {
+		tc.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, tc)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dialer returns the dial function of transports without
+// HTTP/1.1, through the proxy when it uses SOCKS5
+func (c *Client) dialer(what string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	if p := c.proxyURL; p != nil {
+		if !strings.HasPrefix(p.Scheme, "socks") {
+			return nil, fmt.Errorf("HTTP proxies are not supported by %s", what)
+		}
+		d, err := socksProxy(p)
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer).DialContext, nil
+	}
+	if c.config.DialContext != nil {
+		return c.config.DialContext, nil
+	}
+	return (&net.Dialer{}).DialContext, nil
+}
+
+func validTransport(t string) bool {
+	switch t {
+	case "", TransportAuto, TransportWebsocket, TransportPoll, TransportH2:
+		return true
+	}
+	return false
+}
@@ -2,49 +2,118 @@ package chserver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/audit"
 	"github.com/jpillora/chisel/share/ccrypto"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/egress"
+	"github.com/jpillora/chisel/share/metrics"
+	"github.com/jpillora/chisel/share/quota"
 	"github.com/jpillora/chisel/share/settings"
+	"github.com/jpillora/chisel/share/traffic"
+	"github.com/jpillora/chisel/share/tunnel"
 	"github.com/jpillora/requestlog"
 	"golang.org/x/crypto/ssh"
 )
 
 // Config is the configuration for the chisel service
 type Config struct {
-	KeySeed   string
-	KeyFile   string
-	AuthFile  string
-	Auth      string
+	KeySeed  string
+	KeyFile  string
+	AuthFile string
+	Auth     string
+	//Users, when set, are used instead of an AuthFile
+	Users []*settings.User
+	//AuthorizedKeys is the path of an authorized_keys
+	//file, see settings.ParseAuthorizedKeys
+	AuthorizedKeys string
+	//HostCert is the path of an SSH host certificate of the key
+	HostCert string
 	Proxy     string
 	Socks5    bool
 	Reverse   bool
 	KeepAlive time.Duration
 	TLS       TLSConfig
+	Admin     string
+	AdminAuth string
+	Metrics   string
+	//Obfuscation profile, see traffic.ParseObfuscation
+	Obfuscation string
+	//Cover traffic setting, see traffic.ParseCoverTraffic
+	Cover string
+	//Control is the path of the control socket
+	Control string
+	//Egress restricts the destinations of outbound
+	//connections, see egress.New
+	Egress egress.Config
+	//QuotaFile is the path of the file in which the
+	//usage of the users is saved, see quota.Open
+	QuotaFile string
+	//AuditLog is the path of the audit log,
+	//or - for stdout, see audit.Open
+	AuditLog string
+	//LogLevel is the minimum level logged, one of
+	//trace, debug, info, warn or error
+	LogLevel string
+	//LogFormat of the server log, text or json
+	LogFormat string
+	//HTTP2 serves clients over HTTP/2 streams, negotiated
+	//with TLS or with prior knowledge (h2c) without
+	HTTP2 bool
+	//Listen are additional addresses on which clients connect
+	//without HTTP, in the form tcp://host:port or tls://host:port
+	Listen []string
 }
 
 // Server respresent a chisel service
 type Server struct {
 	*cio.Logger
-	config       *Config
-	fingerprint  string
-	httpServer   *cnet.HTTPServer
-	reverseProxy *httputil.ReverseProxy
-	sessCount    int32
-	sessions     *settings.Users
-	sshConfig    *ssh.ServerConfig
-	users        *settings.UserIndex
+	config        *Config
+	fingerprint   string
+	httpServer    *cnet.HTTPServer
+	poll          *cnet.PollServer
+	reverseProxy  *httputil.ReverseProxy
+	sessCount     int32
+	sessions      *settings.Users
+	live          *sessionIndex
+	adminServer   *cnet.HTTPServer
+	metrics       serverMetrics
+	metricsServer *cnet.HTTPServer
+	sshConfig     *ssh.ServerConfig
+	users         *settings.UserIndex
+	keys          *settings.KeyIndex
+	listenersMut  sync.Mutex
+	listeners     map[listenerKey]*tunnel.Listener
+	obfuscation   traffic.Obfuscation
+	cover         traffic.CoverTraffic
+	egress        *egress.Policy
+	limitsMut     sync.Mutex
+	limits        map[string]*cio.Limiter
+	quota         *quota.Store
+	audit         *audit.Log
+	started       time.Time
+	addr          string
+	control       net.Listener
+	tlsConfig     *tls.Config
+	rawMut        sync.Mutex
+	raw           []net.Listener
 }
 
 var upgrader = websocket.Upgrader{
@@ -60,24 +129,86 @@ func NewServer(c *Config) (*Server, error) {
 		httpServer: cnet.NewHTTPServer(),
 		Logger:     cio.NewLogger("server"),
 		sessions:   settings.NewUsers(),
+		live:       newSessionIndex(),
+		listeners:  map[listenerKey]*tunnel.Listener{},
+		limits:     map[string]*cio.Limiter{},
 	}
 	server.Info = true
+	server.poll = cnet.NewPollServer(settings.EnvDuration("POLL_TIMEOUT", 25*time.Second), server.handlePoll)
+	if c.HTTP2 {
+		p := &http.Protocols{}
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+		server.httpServer.Protocols = p
+	}
+	if err := server.Configure(c.LogLevel, c.LogFormat); err != nil {
+		return nil, err
+	}
+	obfuscation, err := traffic.ParseObfuscation(c.Obfuscation)
+	if err != nil {
+		return nil, server.Errorf("%s", err)
+	}
+	server.obfuscation = obfuscation
+	server.cover, err = traffic.ParseCoverTraffic(c.Cover)
+	if err != nil {
+		return nil, server.Errorf("%s", err)
+	}
+	for _, l := range c.Listen {
+		if _, _, err := parseRaw(l); err != nil {
+			return nil, server.Errorf("%s", err)
+		}
+	}
+	server.egress, err = egress.New(c.Egress)
+	if err != nil {
+		return nil, server.Errorf("%s", err)
+	}
+	server.quota, err = quota.Open(c.QuotaFile)
+	if err != nil {
+		return nil, server.Errorf("%s", err)
+	}
+	if c.AuditLog != "" {
+		server.audit, err = audit.Open(c.AuditLog)
+		if err != nil {
+			return nil, server.Errorf("audit log: %s", err)
+		}
+	}
 	server.users = settings.NewUserIndex(server.Logger)
+	server.users.OnReload(server.reloadLimits)
 	if c.AuthFile != "" {
 		if err := server.users.LoadUsers(c.AuthFile); err != nil {
 			return nil, err
 		}
 	}
+	if len(c.Users) > 0 {
+		if c.AuthFile != "" {
+			return nil, server.Errorf("Users cannot be combined with an auth file")
+		}
+		for _, u := range c.Users {
+			if err := settings.ValidatePassword(u.Pass); err != nil {
+				return nil, server.Errorf("%s for user %s", err, u.Name)
+			}
+			server.users.AddUser(u)
+		}
+	}
+	server.keys = settings.NewKeyIndex(server.Logger)
+	if c.AuthorizedKeys != "" {
+		if err := server.keys.LoadKeys(c.AuthorizedKeys); err != nil {
+			return nil, err
+		}
+	}
 	if c.Auth != "" {
 		u := &settings.User{Addrs: []*regexp.Regexp{settings.UserAllowAll}}
 		u.Name, u.Pass = settings.ParseAuth(c.Auth)
+		if err := settings.ValidatePassword(u.Pass); err != nil {
+			return nil, server.Errorf("%s", err)
+		}
 		if u.Name != "" {
 			server.users.AddUser(u)
 		}
 	}
 
 	var pemBytes []byte
-	var err error
 	if c.KeyFile != "" {
 		var key []byte
 
@@ -117,7 +248,27 @@ func NewServer(c *Config) (*Server, error) {
 		ServerVersion:    chshare.MaskedSSHServerVersion,
 		PasswordCallback: server.authUser,
 	}
+	if c.AuthorizedKeys != "" {
+		server.sshConfig.PublicKeyCallback = server.authKey
+	}
 	server.sshConfig.AddHostKey(private)
+	//clients which trust the certificate authority
+	//no longer depend on the fingerprint of the key
+	if c.HostCert != "" {
+		cert, err := ccrypto.LoadCertificate(c.HostCert)
+		if err != nil {
+			return nil, server.Errorf("%s", err)
+		}
+		if cert.CertType != ssh.HostCert {
+			return nil, server.Errorf("%s is not a host certificate", c.HostCert)
+		}
+		signer, err := ssh.NewCertSigner(cert, private)
+		if err != nil {
+			return nil, server.Errorf("Host certificate does not match the key (%s)", err)
+		}
+		server.sshConfig.AddHostKey(signer)
+		server.Infof("Host certificate %q", cert.KeyId)
+	}
 	//setup reverse proxy
 	if c.Proxy != "" {
 		u, err := url.Parse(c.Proxy)
@@ -136,6 +287,24 @@ func NewServer(c *Config) (*Server, error) {
 			r.Host = u.Host
 		}
 	}
+	//opt-in prometheus metrics
+	var registry *metrics.Registry
+	if c.Metrics != "" {
+		registry = metrics.NewRegistry()
+		server.metricsServer = cnet.NewHTTPServer()
+	}
+	server.metrics = newServerMetrics(registry)
+	//admin api requires its own credentials
+	if c.Admin != "" {
+		u, p := settings.ParseAuth(c.AdminAuth)
+		if u == "" {
+			return nil, server.Errorf("Admin API requires admin credentials (<user:pass>)")
+		}
+		if err := settings.ValidatePassword(p); err != nil {
+			return nil, server.Errorf("Admin API credentials: %s", err)
+		}
+		server.adminServer = cnet.NewHTTPServer()
+	}
 	//print when reverse tunnelling is enabled
 	if c.Reverse {
 		server.Infof("Reverse tunnelling enabled")
@@ -161,7 +330,7 @@ func (s *Server) Start(host, port string) error {
 // and can be closed by cancelling the provided context
 func (s *Server) StartContext(ctx context.Context, host, port string) error {
 	s.Infof("Fingerprint %s", s.fingerprint)
-	if s.users.Len() > 0 {
+	if s.authEnabled() {
 		s.Infof("User authentication enabled")
 	}
 	if s.reverseProxy != nil {
@@ -171,22 +340,87 @@ func (s *Server) StartContext(ctx context.Context, host, port string) error {
 	if err != nil {
 		return err
 	}
+	s.started = time.Now()
+	s.addr = l.Addr().String()
 	h := http.Handler(http.HandlerFunc(s.handleClientHandler))
 	if s.Debug {
 		o := requestlog.DefaultOptions
 		o.TrustProxy = true
 		h = requestlog.WrapWith(h, o)
 	}
-	return s.httpServer.GoServe(ctx, l, h)
+	//the other listeners may fail, so they start before
+	//serving, and a failure leaves none of them open
+	if err := s.startListeners(ctx); err != nil {
+		l.Close()
+		s.closeListeners()
+		return err
+	}
+	if err := s.httpServer.GoServe(ctx, l, h); err != nil {
+		s.closeListeners()
+		return err
+	}
+	//polling sessions outlive their requests
+	go func() {
+		<-ctx.Done()
+		s.poll.Close()
+	}()
+	go s.saveQuota(ctx)
+	return nil
+}
+
+// startListeners starts the raw, admin, control and
+// metrics listeners which are configured
+func (s *Server) startListeners(ctx context.Context) error {
+	if err := s.startRaw(ctx); err != nil {
+		return err
+	}
+	if s.adminServer != nil {
+		if err := s.startAdmin(ctx); err != nil {
+			return err
+		}
+	}
+	if s.config.Control != "" {
+		if err := s.startControl(ctx); err != nil {
+			return err
+		}
+	}
+	if s.metricsServer != nil {
+		return s.startMetrics(ctx)
+	}
+	return nil
+}
+
+// closeListeners closes the listeners of startListeners
+func (s *Server) closeListeners() {
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	if s.control != nil {
+		s.control.Close()
+	}
+	s.closeRaw()
 }
 
 // Wait waits for the http server to close
 func (s *Server) Wait() error {
-	return s.httpServer.Wait()
+	err := s.httpServer.Wait()
+	//removes the control socket before returning
+	if s.control != nil {
+		s.control.Close()
+	}
+	return err
 }
 
 // Close forcibly closes the http server
 func (s *Server) Close() error {
+	s.closeListeners()
+	if err := s.quota.Save(); err != nil {
+		s.Logf(slog.LevelError, "%s", err)
+	}
+	s.poll.Close()
 	return s.httpServer.Close()
 }
 
@@ -198,14 +432,18 @@ func (s *Server) GetFingerprint() string {
 // authUser is responsible for validating the ssh user / password combination
 func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	// check if user authentication is enabled and if not, allow all
-	if s.users.Len() == 0 {
+	if !s.authEnabled() {
 		return nil, nil
 	}
 	// check the user exists and has matching password
 	n := c.User()
 	user, found := s.users.Get(n)
-	if !found || user.Pass != string(password) {
+	if !found {
+		settings.RejectPassword(string(password))
+	}
+	if !found || !user.CheckPassword(string(password)) {
 		s.Debugf("Login failed for user: %s", n)
+		s.metrics.authFailures.Inc()
 		return nil, errors.New("Invalid authentication for username: %s")
 	}
 	// insert the user session map
@@ -214,6 +452,107 @@ func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions
 	return nil, nil
 }
 
+// ssh.Permissions extensions of authenticated keys and certificates
+const (
+	authKeyExtension    = "chisel-key"
+	authCertExtension   = "chisel-cert-authority"
+	authExpiryExtension = "chisel-cert-expiry"
+)
+
+// authKey is responsible for validating the ssh public key
+func (s *Server) authKey(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		return s.authCert(c, cert)
+	}
+	if _, found := s.keys.Get(key); !found {
+		s.Debugf("Login failed for key: %s", ssh.FingerprintSHA256(key))
+		s.metrics.authFailures.Inc()
+		return nil, errors.New("Invalid authentication for key")
+	}
+	// keys may be offered without being used, so the user
+	// is only resolved once the connection is authenticated
+	return &ssh.Permissions{
+		Extensions: map[string]string{authKeyExtension: string(key.Marshal())},
+	}, nil
+}
+
+// authCert is responsible for validating ssh certificates, which
+// must be signed by an authority of the authorized keys, be valid
+// at this time, and list the ssh user as one of its principals
+func (s *Server) authCert(c ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			_, found := s.keys.Authority(auth)
+			return found
+		},
+		SupportedCriticalOptions: []string{"source-address"},
+	}
+	_, err := checker.Authenticate(c, cert)
+	if err == nil && len(cert.ValidPrincipals) == 0 {
+		//otherwise the client could choose any user
+		err = errors.New("certificate has no principals")
+	}
+	if err != nil {
+		s.Debugf("Login failed for certificate %q: %s", cert.KeyId, strings.TrimPrefix(err.Error(), "ssh: "))
+		s.metrics.authFailures.Inc()
+		return nil, err
+	}
+	return &ssh.Permissions{
+		//source-address is enforced by the ssh server
+		CriticalOptions: cert.CriticalOptions,
+		Extensions: map[string]string{
+			authCertExtension:   string(cert.SignatureKey.Marshal()),
+			authExpiryExtension: strconv.FormatUint(cert.ValidBefore, 10),
+		},
+	}, nil
+}
+
+// authEnabled returns whether clients must authenticate
+func (s *Server) authEnabled() bool {
+	return s.users.Len() > 0 || s.config.AuthorizedKeys != ""
+}
+
+// authenticatedUser returns the user of an authenticated connection
+func (s *Server) authenticatedUser(c *ssh.ServerConn) (*settings.User, error) {
+	if c.Permissions != nil {
+		if k, ok := c.Permissions.Extensions[authKeyExtension]; ok {
+			key, err := ssh.ParsePublicKey([]byte(k))
+			if err != nil {
+				return nil, err
+			}
+			user, found := s.keys.Get(key)
+			if !found {
+				return nil, errors.New("key was revoked")
+			}
+			return user, nil
+		}
+		if k, ok := c.Permissions.Extensions[authCertExtension]; ok {
+			key, err := ssh.ParsePublicKey([]byte(k))
+			if err != nil {
+				return nil, err
+			}
+			authority, found := s.keys.Authority(key)
+			if !found {
+				return nil, errors.New("certificate authority was revoked")
+			}
+			//principals have the access of the user of the same
+			//name, within that granted by the authority, otherwise
+			//the access granted by the authority
+			if user, found := s.users.Get(c.User()); found {
+				return user.Within(authority.Addrs), nil
+			}
+			return &settings.User{Name: c.User(), Addrs: authority.Addrs}, nil
+		}
+	}
+	sid := string(c.SessionID())
+	user, found := s.sessions.Get(sid)
+	if !found {
+		panic("bug in ssh auth handler")
+	}
+	s.sessions.Del(sid)
+	return user, nil
+}
+
 // AddUser adds a new user into the server user index
 func (s *Server) AddUser(user, pass string, addrs ...string) error {
 	authorizedAddrs := []*regexp.Regexp{}
@@ -241,4 +580,5 @@ func (s *Server) DeleteUser(user string) {
 // Use nil to remove all.
 func (s *Server) ResetUsers(users []*settings.User) {
 	s.users.Reset(users)
+	s.reloadLimits()
 }
@@ -0,0 +1,95 @@
+package chserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// startAdmin starts the admin api on its own listener
+func (s *Server) startAdmin(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.config.Admin)
+	if err != nil {
+		return s.Errorf("admin: %s", err)
+	}
+	s.Infof("Admin API listening on http://%s", l.Addr())
+	return s.adminServer.GoServe(ctx, l, s.adminHandler())
+}
+
+// adminHandler serves the admin api:
+//
+//	GET    /sessions       list connected sessions
+//	GET    /sessions/{id}  show a single session
+//	DELETE /sessions/{id}  forcibly disconnect a session
+//	GET    /metrics        prometheus metrics (when enabled)
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Sessions())
+	})
+	mux.HandleFunc("GET /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := s.adminSession(w, r)
+		if ok {
+			writeJSON(w, http.StatusOK, sess.snapshot())
+		}
+	})
+	mux.HandleFunc("DELETE /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := s.adminSession(w, r)
+		if !ok {
+			return
+		}
+		if err := s.CloseSession(sess.info.ID); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if s.metrics.registry != nil {
+		mux.Handle("GET /metrics", s.metrics.registry)
+	}
+	return s.adminAuth(mux)
+}
+
+// adminSession finds the session of the {id} path segment
+func (s *Server) adminSession(w http.ResponseWriter, r *http.Request) (*session, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid session id"})
+		return nil, false
+	}
+	sess, ok := s.live.get(int32(id))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return nil, false
+	}
+	return sess, true
+}
+
+// adminAuth guards the admin api with basic authentication
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	admin := &settings.User{}
+	admin.Name, admin.Pass = settings.ParseAuth(s.config.AdminAuth)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, _ := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(admin.Name)) == 1
+		passOK := admin.CheckPassword(p)
+		if !userOK || !passOK {
+			s.Debugf("Admin login failed for user: %s", u)
+			w.Header().Set("WWW-Authenticate", `Basic realm="chisel"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,43 @@
+package chserver
+
+import (
+	"log/slog"
+
+	"github.com/jpillora/chisel/share/audit"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// auditLog writes the event to the audit log, if enabled
+func (s *Server) auditLog(e audit.Event) {
+	if err := s.audit.Write(e); err != nil {
+		s.Logf(slog.LevelError, "Failed to write audit log: %s", err)
+	}
+}
+
+// auditDenied records a session which was denied
+func (s *Server) auditDenied(e audit.Event, err error) {
+	e.Type = audit.SessionDenied
+	e.Reason = err.Error()
+	s.auditLog(e)
+}
+
+// auditRemotes records an update to the remotes of a session,
+// and the reason it failed
+func (s *Server) auditRemotes(e audit.Event, u *settings.RemotesUpdate, err error) {
+	e.Type = audit.RemotesUpdate
+	e.Remotes = remoteStrings(u.Add)
+	e.Removed = remoteStrings(u.Remove)
+	e.Limited = remoteStrings(u.Limit)
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	s.auditLog(e)
+}
+
+func remoteStrings(rs settings.Remotes) []string {
+	var out []string
+	for _, r := range rs {
+		out = append(out, r.String())
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package chserver
+
+import (
+	"context"
+	"time"
+
+	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/control"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// Status describes the state of a running server
+type Status struct {
+	Role     string        `json:"role"`
+	Version  string        `json:"version"`
+	Started  time.Time     `json:"started"`
+	Listen   string        `json:"listen"`
+	Sessions []SessionInfo `json:"sessions"`
+	//Connections sums the connections
+	//of the listeners of all sessions
+	Connections tunnel.ConnectionStats `json:"connections"`
+	Runtime     control.Runtime        `json:"runtime"`
+}
+
+// Status returns the current state of the server
+func (s *Server) Status() Status {
+	st := Status{
+		Role:     "server",
+		Version:  chshare.BuildVersion,
+		Started:  s.started,
+		Listen:   s.addr,
+		Sessions: s.Sessions(),
+		Runtime:  control.ReadRuntime(),
+	}
+	for _, sess := range st.Sessions {
+		st.Connections.Add(tunnel.TotalStats(sess.Listeners))
+	}
+	return st
+}
+
+// startControl serves the control socket
+func (s *Server) startControl(ctx context.Context) error {
+	l, err := control.Listen(s.config.Control)
+	if err != nil {
+		return s.Errorf("control: %s", err)
+	}
+	s.control = l
+	s.Infof("Control socket listening on %s", s.config.Control)
+	go control.Serve(ctx, l, control.Handlers{
+		"status": func() (interface{}, error) {
+			return s.Status(), nil
+		},
+	})
+	return nil
+}
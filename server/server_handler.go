@@ -1,12 +1,21 @@
 package chserver
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	chshare "github.com/jpillora/chisel/share"
+	"github.com/jpillora/chisel/share/audit"
+	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/chisel/share/tunnel"
@@ -18,6 +27,16 @@ import (
 func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 	//websockets upgrade - accept masked protocol or no protocol
 	//Actual protocol verification happens via SSH custom request after handshake
+	//http polling, for proxies which strip the upgrade
+	if cnet.IsPoll(r) {
+		s.poll.ServeHTTP(w, r)
+		return
+	}
+	//http/2 streams, see Config.HTTP2
+	if cnet.IsStream(r) {
+		s.handleStream(w, r)
+		return
+	}
 	upgrade := strings.ToLower(r.Header.Get("Upgrade"))
 	protocol := r.Header.Get("Sec-WebSocket-Protocol")
 	if upgrade == "websocket" {
@@ -56,32 +75,66 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 
 // handleWebsocket is responsible for handling the websocket connection
 func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
-	id := atomic.AddInt32(&s.sessCount, 1)
-	l := s.Fork("session#%d", id)
 	wsConn, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
-		l.Debugf("Failed to upgrade (%s)", err)
+		s.Debugf("Failed to upgrade (%s)", err)
 		return
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
+	s.handleConn(req.Context(), cnet.NewWebSocketConnWith(wsConn, s.obfuscation), req.RemoteAddr)
+}
+
+// handlePoll handles the connection of a session of the
+// http polling transport, which outlives its requests
+func (s *Server) handlePoll(c net.Conn) {
+	s.handleConn(context.Background(), cnet.NewObfuscatedConn(c, s.obfuscation), c.RemoteAddr().String())
+}
+
+// handleStream handles the connection of the http/2 stream
+// transport, which lasts as long as the request
+func (s *Server) handleStream(w http.ResponseWriter, req *http.Request) {
+	err := cnet.ServeStream(w, req, func(c net.Conn) {
+		s.handleConn(req.Context(), cnet.NewObfuscatedConn(c, s.obfuscation), req.RemoteAddr)
+	})
+	if err != nil {
+		s.Debugf("Failed to serve stream (%s)", err)
+	}
+}
+
+// handleConn is responsible for the SSH connection of a
+// client over any transport, and blocks until it closes
+func (s *Server) handleConn(ctx context.Context, nc net.Conn, remoteAddr string) {
+	id := atomic.AddInt32(&s.sessCount, 1)
+	l := s.ForkID("session", id).With("source", remoteAddr)
+	conn := cnet.NewCountConn(nc)
 	// perform SSH handshake on net.Conn
-	l.Debugf("Handshaking with %s...", req.RemoteAddr)
+	l.Debugf("Handshaking with %s...", remoteAddr)
+	ev := audit.Event{Session: id, Source: remoteAddr}
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
 	if err != nil {
 		s.Debugf("Failed to handshake (%s)", err)
+		s.auditDenied(ev, err)
 		return
 	}
 	// pull the users from the session map
 	var user *settings.User
-	if s.users.Len() > 0 {
-		sid := string(sshConn.SessionID())
-		u, ok := s.sessions.Get(sid)
-		if !ok {
-			panic("bug in ssh auth handler")
+	if s.authEnabled() {
+		u, err := s.authenticatedUser(sshConn)
+		if err != nil {
+			l.Debugf("Failed to authenticate (%s)", err)
+			ev.User = sshConn.User()
+			s.auditDenied(ev, err)
+			sshConn.Close()
+			return
 		}
 		user = u
-		s.sessions.Del(sid)
 	}
+	//key users are named by the authorized keys file
+	name := sshConn.User()
+	if user != nil {
+		name = user.Name
+	}
+	ev.User = name
+	l = l.With("user", name)
 	// chisel server handshake (reverse of client handshake)
 	// verify configuration
 	l.Debugf("Verifying configuration")
@@ -91,12 +144,14 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	case r = <-reqs:
 	case <-time.After(settings.EnvDuration("CONFIG_TIMEOUT", 10*time.Second)):
 		l.Debugf("Timeout waiting for configuration")
+		s.auditDenied(ev, errors.New("timeout waiting for configuration"))
 		sshConn.Close()
 		return
 	}
 	failed := func(err error) {
 		l.Debugf("Failed: %s", err)
 		r.Reply(false, []byte(err.Error()))
+		s.auditDenied(ev, err)
 	}
 	if r.Type != "config" {
 		failed(s.Errorf("expecting config request"))
@@ -116,43 +171,136 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	if cv != sv {
 		l.Infof("Client version (%s) differs from server version (%s)", cv, sv)
 	}
-	//validate remotes
-	for _, r := range c.Remotes {
-		//if user is provided, ensure they have
-		//access to the desired remotes
-		if user != nil {
-			addr := r.UserAddr()
-			if !user.HasAccess(addr) {
-				failed(s.Errorf("access to '%s' denied", addr))
-				return
-			}
+	ev.Version = cv
+	ev.Remotes = remoteStrings(c.Remotes)
+	//enforce the policy of the user
+	if user != nil {
+		if err := s.checkUser(user, remoteAddr); err != nil {
+			l.Warnf("Denied user %s (%s)", user.Name, err)
+			failed(err)
+			return
 		}
-		//confirm reverse tunnels are allowed
-		if r.Reverse && !s.config.Reverse {
-			l.Debugf("Denied reverse port forwarding request, please enable --reverse")
-			failed(s.Errorf("Reverse port forwaring not enabled on server"))
+	}
+	//extra connections join the session of their primary
+	//connection, sharing its tunnel, limits and lifetime
+	if c.Join != "" {
+		sess, err := s.live.join(c.Join, name, conn)
+		if err != nil {
+			failed(err)
 			return
 		}
-		//confirm reverse tunnel is available
-		if r.Reverse && !r.CanListen() {
-			failed(s.Errorf("Server cannot listen on %s", r.String()))
+		defer sess.leave(conn)
+		r.Reply(true, nil)
+		l.Debugf("Joined session#%d", sess.info.ID)
+		ctx, cancel := context.WithCancel(sess.ctx)
+		defer cancel()
+		if err := sess.tun.BindExtraSSH(ctx, sshConn, reqs, chans); err != nil && !strings.HasSuffix(err.Error(), "EOF") {
+			l.Debugf("Closed connection (%s)", err)
+		} else {
+			l.Debugf("Closed connection")
+		}
+		return
+	}
+	if user != nil {
+		if !s.live.reserve(name, user.MaxSessions) {
+			err := fmt.Errorf("too many sessions (max %d)", user.MaxSessions)
+			l.Warnf("Denied user %s (%s)", user.Name, err)
+			failed(err)
+			return
+		}
+		defer s.live.release(name)
+	}
+	//validate remotes
+	for _, r := range c.Remotes {
+		if err := s.validateRemote(l, user, r); err != nil {
+			failed(err)
 			return
 		}
 	}
 	//successfuly validated config!
-	r.Reply(true, nil)
+	started := time.Now()
+	ev.Type = audit.SessionStart
+	s.auditLog(ev)
+	//sessions end when their certificate or user expires
+	if expiry, ok := sessionExpiry(sshConn, user); ok {
+		l.Debugf("Session valid until %s", expiry)
+		timer := time.AfterFunc(time.Until(expiry), func() {
+			l.Infof("Session expired, closing connection")
+			sshConn.Close()
+		})
+		defer timer.Stop()
+	}
+	//track session until disconnected
+	sess := &session{
+		info: SessionInfo{
+			ID:         id,
+			User:       name,
+			RemoteAddr: remoteAddr,
+			Version:    cv,
+			Connected:  time.Now(),
+		},
+		conn: conn,
+		ssh:  sshConn,
+	}
+	for _, r := range c.Remotes {
+		sess.info.Remotes = append(sess.info.Remotes, r.String())
+	}
 	//tunnel per ssh connection
 	tunnel := tunnel.New(tunnel.Config{
-		Logger:    l,
-		Inbound:   s.config.Reverse, //server is inbound for reverse proxies
-		Outbound:  true, //server always accepts outbound
-		Socks:     s.config.Socks5,
-		KeepAlive: s.config.KeepAlive,
+		Logger:      l,
+		Inbound:     s.config.Reverse, //server is inbound for reverse proxies
+		Outbound:    true,             //server always accepts outbound
+		Socks:       s.config.Socks5,
+		KeepAlive:   s.config.KeepAlive,
+		User:        user,
+		Egress:      s.egress,
+		Limit:       s.userLimiter(user),
+		Accounting:  s.accounting(user),
+		Audit: func(e audit.Event) {
+			e.Session, e.User = id, name
+			if e.Source == "" {
+				e.Source = remoteAddr
+			}
+			s.auditLog(e)
+		},
+		Metrics:     s.metrics.tunnel,
+		Obfuscation: s.obfuscation,
+		Cover:       s.cover,
+		Listener: func(addr string) *tunnel.Listener {
+			return s.lookupListener(name, addr)
+		},
 	})
+	tunnel.MetricRemotes(c.Remotes, nil)
+	defer tunnel.CloseMetrics()
 	//bind
-	eg, ctx := errgroup.WithContext(req.Context())
+	eg, ctx := errgroup.WithContext(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sess.tun, sess.ctx = tunnel, ctx
+	//clients with extra connections are given a
+	//token with which they join the session
+	var token []byte
+	if c.Connections > 1 {
+		sess.join = rand.Text()
+		sess.maxExtras = min(c.Connections, settings.MaxConnections) - 1
+		token = []byte(sess.join)
+	}
+	s.live.add(sess)
+	defer s.live.del(id)
+	r.Reply(true, token)
+	s.metrics.sessions.Inc()
+	defer s.metrics.sessions.Dec()
+	s.metrics.sessionsTotal.Inc()
+	//remotes may be added and removed while connected
+	tunnel.Remotes = func(u *settings.RemotesUpdate) error {
+		err := s.updateRemotes(ctx, l, tunnel, user, sess, u)
+		s.auditRemotes(ev, u, err)
+		return err
+	}
 	eg.Go(func() error {
 		//connected, handover ssh connection for tunnel to use, and block
+		//once disconnected, release any reversed-remotes of this session
+		defer cancel()
 		return tunnel.BindSSH(ctx, sshConn, reqs, chans)
 	})
 	eg.Go(func() error {
@@ -161,14 +309,135 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		if len(serverInbound) == 0 {
 			return nil
 		}
-		// In reverse proxy mode, server should NOT bind remotes
-		// Client will bind the reverse proxy ports locally
-		return nil
+		//block
+		return tunnel.BindRemotes(ctx, serverInbound)
 	})
 	err = eg.Wait()
+	ev.Type, ev.Remotes = audit.SessionEnd, nil
+	ev.Sent, ev.Received = conn.Sent(), conn.Received()
+	ev.DurationMs = time.Since(started).Milliseconds()
 	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
 		l.Debugf("Closed connection (%s)", err)
+		ev.Reason = err.Error()
 	} else {
 		l.Debugf("Closed connection")
 	}
+	s.auditLog(ev)
+}
+
+// validateRemote checks a remote requested by a client
+func (s *Server) validateRemote(l *cio.Logger, user *settings.User, r *settings.Remote) error {
+	//if user is provided, ensure they have
+	//access to the desired remotes (the
+	//destinations of socks are checked as
+	//they connect)
+	if user != nil && !(r.Socks && !r.Reverse) {
+		addr := r.UserAddr()
+		if !user.HasAccess(addr) {
+			return s.Errorf("access to '%s' denied", addr)
+		}
+	}
+	//confirm the user may use socks and reverse tunnels
+	if user != nil && r.Socks && !r.Reverse && user.DenySocks {
+		return s.Errorf("SOCKS5 access denied")
+	}
+	if user != nil && r.Reverse && user.DenyReverse {
+		return s.Errorf("Reverse port forwarding denied")
+	}
+	//confirm reverse tunnels are allowed
+	if r.Reverse && !s.config.Reverse {
+		l.Debugf("Denied reverse port forwarding request, please enable --reverse")
+		return s.Errorf("Reverse port forwaring not enabled on server")
+	}
+	//confirm reverse tunnel is available
+	if r.Reverse && !r.CanListen() {
+		return s.Errorf("Server cannot listen on %s", r.String())
+	}
+	return nil
+}
+
+// checkUser checks the user may connect from the remote address
+func (s *Server) checkUser(user *settings.User, remoteAddr string) error {
+	if user.Expired(time.Now()) {
+		return errors.New("user expired")
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if !user.HasSource(net.ParseIP(host)) {
+		return fmt.Errorf("source address %s denied", host)
+	}
+	return s.quota.Check(user.Name, userLimits(user))
+}
+
+// updateRemotes applies an update to the remotes of a session,
+// added remotes are validated like those of its config. Updates
+// are checked and added remotes bound before anything is removed,
+// so that a failing update leaves the remotes as they were.
+func (s *Server) updateRemotes(ctx context.Context, l *cio.Logger, t *tunnel.Tunnel, user *settings.User, sess *session, u *settings.RemotesUpdate) error {
+	add, remove, limit := u.Add.Reversed(true), u.Remove.Reversed(true), u.Limit.Reversed(true)
+	for _, r := range u.Add {
+		if err := s.validateRemote(l, user, r); err != nil {
+			return err
+		}
+	}
+	added := map[string]bool{}
+	for _, r := range add {
+		added[r.Encode()] = true
+	}
+	for _, r := range remove {
+		if !t.HasRemote(r) {
+			return fmt.Errorf("remote %s is not bound", r)
+		}
+	}
+	for _, r := range limit {
+		if !t.HasRemote(r) && !added[r.Encode()] {
+			return fmt.Errorf("remote %s is not bound", r)
+		}
+	}
+	for i, r := range add {
+		if err := t.AddRemote(ctx, r); err != nil {
+			for _, r := range add[:i] {
+				t.RemoveRemote(r)
+			}
+			return err
+		}
+	}
+	for _, r := range remove {
+		t.RemoveRemote(r)
+	}
+	for _, r := range limit {
+		t.SetRemoteLimit(r)
+	}
+	sess.updateRemotes(u)
+	t.MetricRemotes(u.Add, u.Remove)
+	l.Debugf("Remotes updated (%d added, %d removed, %d limited)", len(u.Add), len(u.Remove), len(u.Limit))
+	return nil
+}
+
+// sessionExpiry returns when the session of a connection expires,
+// the earliest of its certificate and user expiry
+func sessionExpiry(c *ssh.ServerConn, user *settings.User) (time.Time, bool) {
+	expiry, ok := certExpiry(c)
+	if user != nil && !user.Expires.IsZero() && (!ok || user.Expires.Before(expiry)) {
+		return user.Expires, true
+	}
+	return expiry, ok
+}
+
+// certExpiry returns when the certificate of a connection expires
+func certExpiry(c *ssh.ServerConn) (time.Time, bool) {
+	if c.Permissions == nil {
+		return time.Time{}, false
+	}
+	s, ok := c.Permissions.Extensions[authExpiryExtension]
+	if !ok {
+		return time.Time{}, false
+	}
+	before, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || before == ssh.CertTimeInfinity || before > math.MaxInt64 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(before), 0), true
 }
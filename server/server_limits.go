@@ -0,0 +1,49 @@
+package chserver
+
+import (
+	"github.com/jpillora/chisel/share/cio"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// userLimiter returns the bandwidth limiter shared by all
+// sessions of the user, created at the user's bandwidth
+func (s *Server) userLimiter(user *settings.User) *cio.Limiter {
+	if user == nil {
+		return nil
+	}
+	s.limitsMut.Lock()
+	defer s.limitsMut.Unlock()
+	l, ok := s.limits[user.Name]
+	if !ok {
+		l = cio.NewLimiter(user.Bandwidth)
+		s.limits[user.Name] = l
+	}
+	return l
+}
+
+// SetUserLimit changes the bandwidth limit of the user in bytes
+// per second (0 is unlimited), applying to the connected sessions
+// of the user, until the users are reloaded
+func (s *Server) SetUserLimit(name string, rate int64) {
+	s.limitsMut.Lock()
+	defer s.limitsMut.Unlock()
+	if l, ok := s.limits[name]; ok {
+		l.SetRate(rate)
+	} else {
+		s.limits[name] = cio.NewLimiter(rate)
+	}
+}
+
+// reloadLimits resets the bandwidth limits
+// of the users to their configured rates
+func (s *Server) reloadLimits() {
+	s.limitsMut.Lock()
+	defer s.limitsMut.Unlock()
+	for name, l := range s.limits {
+		rate := int64(0)
+		if user, found := s.users.Get(name); found {
+			rate = user.Bandwidth
+		}
+		l.SetRate(rate)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 
 	"github.com/jpillora/chisel/share/settings"
 	"golang.org/x/crypto/acme/autocert"
@@ -51,8 +52,12 @@ func (s *Server) listener(host, port string) (net.Listener, error) {
 	proto := "http"
 	if tlsConf != nil {
 		proto += "s"
+		if s.config.HTTP2 && !slices.Contains(tlsConf.NextProtos, "h2") {
+			tlsConf.NextProtos = append([]string{"h2", "http/1.1"}, tlsConf.NextProtos...)
+		}
 		l = tls.NewListener(l, tlsConf)
 	}
+	s.tlsConfig = tlsConf
 	if err == nil {
 		s.Infof("Listening on %s://%s:%s%s", proto, host, port, extra)
 	}
@@ -0,0 +1,53 @@
+package chserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+type listenerKey struct {
+	user, addr string
+}
+
+// Listen returns a net.Listener for the remote address addr (host:port),
+// instead of dialing it. Connections which the clients of user tunnel to
+// addr are returned by Accept, after the usual access checks. Use an
+// empty user to accept connections from any client. The listener is
+// closed when ctx is cancelled. Only TCP remotes are supported.
+func (s *Server) Listen(ctx context.Context, user, addr string) (net.Listener, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	k := listenerKey{user, addr}
+	s.listenersMut.Lock()
+	defer s.listenersMut.Unlock()
+	if _, exists := s.listeners[k]; exists {
+		return nil, fmt.Errorf("already listening on %s", addr)
+	}
+	l := tunnel.NewListener(addr)
+	s.listeners[k] = l
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		s.listenersMut.Lock()
+		if s.listeners[k] == l {
+			delete(s.listeners, k)
+		}
+		s.listenersMut.Unlock()
+	}()
+	return l, nil
+}
+
+// lookupListener finds the listener of user for addr,
+// falling back to the listener for any user
+func (s *Server) lookupListener(user, addr string) *tunnel.Listener {
+	s.listenersMut.Lock()
+	defer s.listenersMut.Unlock()
+	if l, ok := s.listeners[listenerKey{user, addr}]; ok {
+		return l
+	}
+	return s.listeners[listenerKey{"", addr}]
+}
@@ -0,0 +1,39 @@
+package chserver
+
+import (
+	"context"
+
+	"github.com/jpillora/chisel/share/metrics"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// serverMetrics are no-ops unless metrics are enabled
+type serverMetrics struct {
+	registry      *metrics.Registry
+	sessions      *metrics.Value
+	sessionsTotal *metrics.Value
+	authFailures  *metrics.Value
+	tunnel        *tunnel.Metrics
+}
+
+func newServerMetrics(r *metrics.Registry) serverMetrics {
+	m := serverMetrics{
+		registry:      r,
+		sessions:      r.Gauge("chisel_sessions", "Sessions currently connected.").With(),
+		sessionsTotal: r.Counter("chisel_sessions_total", "Sessions connected since start.").With(),
+		authFailures:  r.Counter("chisel_auth_failures_total", "Failed authentication attempts.").With(),
+	}
+	if r != nil {
+		m.tunnel = tunnel.NewMetrics(r)
+	}
+	return m
+}
+
+// startMetrics serves the metrics on their own listener
+func (s *Server) startMetrics(ctx context.Context) error {
+	if err := s.metricsServer.GoListenAndServeContext(ctx, s.config.Metrics, s.metrics.registry); err != nil {
+		return s.Errorf("metrics: %s", err)
+	}
+	s.Infof("Metrics listening on http://%s/metrics", s.config.Metrics)
+	return nil
+}
@@ -0,0 +1,68 @@
+package chserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jpillora/chisel/share/quota"
+	"github.com/jpillora/chisel/share/settings"
+	"github.com/jpillora/chisel/share/tunnel"
+)
+
+// quotaSaveInterval is how often the usage of the users is saved
+const quotaSaveInterval = time.Minute
+
+// userAccount counts the bytes of the sessions of a user
+// against the user's quotas
+type userAccount struct {
+	quota *quota.Store
+	user  *settings.User
+}
+
+func (a userAccount) Admit() error {
+	return a.quota.Check(a.user.Name, userLimits(a.user))
+}
+
+func (a userAccount) Add(n int64) {
+	a.quota.Add(a.user.Name, n)
+}
+
+func userLimits(user *settings.User) quota.Limits {
+	return quota.Limits{Daily: user.QuotaDaily, Monthly: user.QuotaMonthly}
+}
+
+// accounting returns the accounting of a session of the user,
+// anonymous sessions are not accounted
+func (s *Server) accounting(user *settings.User) tunnel.Accounting {
+	if user == nil {
+		return nil
+	}
+	return userAccount{quota: s.quota, user: user}
+}
+
+// Usage returns the bytes transferred by the user,
+// during the current day and month (in UTC)
+func (s *Server) Usage(user string) quota.Usage {
+	return s.quota.Usage(user)
+}
+
+// saveQuota saves the usage of the users periodically,
+// and once more when the context is done
+func (s *Server) saveQuota(ctx context.Context) {
+	t := time.NewTicker(quotaSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.quota.Save(); err != nil {
+				s.Logf(slog.LevelError, "%s", err)
+			}
+			return
+		case <-t.C:
+			if err := s.quota.Save(); err != nil {
+				s.Logf(slog.LevelError, "%s", err)
+			}
+		}
+	}
+}
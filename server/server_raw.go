@@ -0,0 +1,84 @@
+package chserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+// parseRaw parses an address of Config.Listen,
+// returning its scheme (tcp or tls) and host:port
+func parseRaw(raw string) (scheme, addr string, err error) {
+	scheme, addr, ok := strings.Cut(raw, "://")
+	if !ok || (scheme != "tcp" && scheme != "tls") {
+		return "", "", fmt.Errorf("invalid listen address %q (expected tcp:// or tls://)", raw)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q (%s)", raw, err)
+	}
+	return scheme, addr, nil
+}
+
+// startRaw listens on the addresses of Config.Listen, where
+// clients connect without HTTP, SSH running directly over
+// the TCP or TLS stream
+func (s *Server) startRaw(ctx context.Context) error {
+	for _, raw := range s.config.Listen {
+		scheme, addr, err := parseRaw(raw)
+		if err != nil {
+			return err
+		}
+		if scheme == "tls" && s.tlsConfig == nil {
+			return errors.New("tls:// listen addresses require --tls-key and --tls-cert, or --tls-domain")
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		if scheme == "tls" {
+			l = tls.NewListener(l, s.tlsConfig)
+		}
+		s.rawMut.Lock()
+		s.raw = append(s.raw, l)
+		s.rawMut.Unlock()
+		s.Infof("Listening on %s://%s", scheme, l.Addr())
+		go s.serveRaw(ctx, l)
+	}
+	go func() {
+		<-ctx.Done()
+		s.closeRaw()
+	}()
+	return nil
+}
+
+// serveRaw accepts clients until the listener closes
+func (s *Server) serveRaw(ctx context.Context, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.Warnf("Accept failed on %s: %s", l.Addr(), err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s.handleConn(ctx, cnet.NewObfuscatedConn(conn, s.obfuscation), conn.RemoteAddr().String())
+		}()
+	}
+}
+
+// closeRaw closes the listeners of Config.Listen
+func (s *Server) closeRaw() {
+	s.rawMut.Lock()
+	defer s.rawMut.Unlock()
+	for _, l := range s.raw {
+		l.Close()
+	}
+	s.raw = nil
+}
@@ -0,0 +1,210 @@
+package chserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/settings"
+	"github.com/jpillora/chisel/share/tunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes a connected client session
+type SessionInfo struct {
+	ID            int32     `json:"id"`
+	User          string    `json:"user,omitempty"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	Version       string    `json:"version"`
+	Remotes       []string  `json:"remotes"`
+	Connected     time.Time `json:"connected"`
+	BytesSent     int64     `json:"bytesSent"`
+	BytesReceived int64     `json:"bytesReceived"`
+	//LatencyMs is the round trip of the last keepalive ping
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	//Channels are the open channels of the session
+	Channels int32 `json:"channels"`
+	//Listeners are the listeners of reverse remotes
+	Listeners []tunnel.ProxyStatus `json:"listeners"`
+	//Connections are the SSH connections of the session,
+	//its primary one and the extra ones which joined it
+	Connections int `json:"connections"`
+}
+
+// session is a live client session
+type session struct {
+	mut  sync.Mutex
+	info SessionInfo
+	conn *cnet.CountConn
+	ssh  ssh.Conn
+	tun  *tunnel.Tunnel
+	//ctx is done once the session ends
+	ctx context.Context
+	//join is the token with which up to maxExtras
+	//extra connections join the session
+	join      string
+	maxExtras int
+	extras    []*cnet.CountConn
+}
+
+// sessionIndex holds the live sessions by id
+type sessionIndex struct {
+	sync.RWMutex
+	inner map[int32]*session
+	//sessions by join token
+	joins map[string]*session
+	//sessions reserved by each user
+	users map[string]int
+}
+
+func newSessionIndex() *sessionIndex {
+	return &sessionIndex{
+		inner: map[int32]*session{},
+		joins: map[string]*session{},
+		users: map[string]int{},
+	}
+}
+
+// reserve a session of the user, unless it would
+// exceed max sessions (0 is unlimited)
+func (si *sessionIndex) reserve(user string, max int) bool {
+	si.Lock()
+	defer si.Unlock()
+	if max > 0 && si.users[user] >= max {
+		return false
+	}
+	si.users[user]++
+	return true
+}
+
+// release a session reserved by the user
+func (si *sessionIndex) release(user string) {
+	si.Lock()
+	if si.users[user]--; si.users[user] <= 0 {
+		delete(si.users, user)
+	}
+	si.Unlock()
+}
+
+func (si *sessionIndex) add(s *session) {
+	si.Lock()
+	si.inner[s.info.ID] = s
+	if s.join != "" {
+		si.joins[s.join] = s
+	}
+	si.Unlock()
+}
+
+func (si *sessionIndex) del(id int32) {
+	si.Lock()
+	if s, ok := si.inner[id]; ok && s.join != "" {
+		delete(si.joins, s.join)
+	}
+	delete(si.inner, id)
+	si.Unlock()
+}
+
+// join adds an extra connection of the user to the
+// session of the token, within its connection limit
+func (si *sessionIndex) join(token, user string, conn *cnet.CountConn) (*session, error) {
+	si.RLock()
+	s, ok := si.joins[token]
+	si.RUnlock()
+	if !ok || s.info.User != user {
+		return nil, errors.New("no session to join")
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if len(s.extras) >= s.maxExtras {
+		return nil, fmt.Errorf("too many connections (max %d)", s.maxExtras+1)
+	}
+	s.extras = append(s.extras, conn)
+	return s, nil
+}
+
+// leave removes an extra connection from the session
+func (s *session) leave(conn *cnet.CountConn) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for i, c := range s.extras {
+		if c == conn {
+			s.extras = append(s.extras[:i], s.extras[i+1:]...)
+			break
+		}
+	}
+}
+
+func (si *sessionIndex) get(id int32) (*session, bool) {
+	si.RLock()
+	s, ok := si.inner[id]
+	si.RUnlock()
+	return s, ok
+}
+
+// snapshot returns the current state of the session
+func (s *session) snapshot() SessionInfo {
+	s.mut.Lock()
+	info := s.info
+	info.BytesSent = s.conn.Sent()
+	info.BytesReceived = s.conn.Received()
+	for _, c := range s.extras {
+		info.BytesSent += c.Sent()
+		info.BytesReceived += c.Received()
+	}
+	info.Connections = 1 + len(s.extras)
+	s.mut.Unlock()
+	if latency, at := s.tun.LastPing(); !at.IsZero() {
+		info.LatencyMs = float64(latency) / float64(time.Millisecond)
+	}
+	info.Channels = s.tun.Channels()
+	info.Listeners = s.tun.Proxies()
+	return info
+}
+
+// updateRemotes applies a remotes update to the session info
+func (s *session) updateRemotes(u *settings.RemotesUpdate) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	remotes := []string{}
+	for _, r := range s.info.Remotes {
+		removed := false
+		for _, rm := range u.Remove {
+			removed = removed || rm.String() == r
+		}
+		if !removed {
+			remotes = append(remotes, r)
+		}
+	}
+	for _, r := range u.Add {
+		remotes = append(remotes, r.String())
+	}
+	s.info.Remotes = remotes
+}
+
+// Sessions returns the currently connected sessions, ordered by id
+func (s *Server) Sessions() []SessionInfo {
+	s.live.RLock()
+	infos := make([]SessionInfo, 0, len(s.live.inner))
+	for _, sess := range s.live.inner {
+		infos = append(infos, sess.snapshot())
+	}
+	s.live.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// CloseSession forcibly disconnects the session with the given id
+func (s *Server) CloseSession(id int32) error {
+	sess, ok := s.live.get(id)
+	if !ok {
+		return fmt.Errorf("session #%d not found", id)
+	}
+	s.Infof("Closing session#%d", id)
+	return sess.ssh.Close()
+}
@@ -0,0 +1,93 @@
+// Package audit writes an append-only log of sessions and
+// channels, one JSON object per line, for compliance reviews.
+// Unlike the debug log, every event is written, and rejected
+// sessions and channels record the reason they were denied.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	SessionStart  = "session-start"
+	SessionEnd    = "session-end"
+	SessionDenied = "session-denied"
+	Channel       = "channel"
+	ChannelDenied = "channel-denied"
+	RemotesUpdate = "remotes-update"
+)
+
+// Event is a line of the audit log. Sent and Received
+// are the bytes sent to and received from the client.
+// Updates to the remotes of a session record those
+// added in Remotes, and those removed and limited.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Session     int32     `json:"session,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Remotes     []string  `json:"remotes,omitempty"`
+	Removed     []string  `json:"removed,omitempty"`
+	Limited     []string  `json:"limited,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Sent        int64     `json:"sent,omitempty"`
+	Received    int64     `json:"received,omitempty"`
+	DurationMs  int64     `json:"durationMs,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Log writes events to a file. A nil Log discards them.
+type Log struct {
+	mut sync.Mutex
+	w   io.Writer
+	c   io.Closer
+}
+
+// Open appends to the log file at path, which is
+// created if needed, or to stdout when path is "-"
+func Open(path string) (*Log, error) {
+	if path == "-" {
+		return &Log{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{w: f, c: f}, nil
+}
+
+// Write appends the event to the log,
+// at the current time when it is unset
+func (l *Log) Write(e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	//a single write per line, so that lines are never interleaved
+	b = append(b, '\n')
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	_, err = l.w.Write(b)
+	return err
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil || l.c == nil {
+		return nil
+	}
+	return l.c.Close()
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := 0; i < 2; i++ {
+		//reopening appends
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Write(Event{Type: Channel, User: "foo", Destination: "example.com:80", Sent: 10})
+		l.Close()
+	}
+	var none *Log
+	none.Write(Event{Type: Channel})
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		e := Event{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != Channel || e.User != "foo" || e.Sent != 10 || e.Time.IsZero() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("expected 2 lines, got %d", lines)
+	}
+}
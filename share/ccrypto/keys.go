@@ -1,6 +1,7 @@
 package ccrypto
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
@@ -33,3 +34,71 @@ func FingerprintKey(k ssh.PublicKey) string {
 	bytes := sha256.Sum256(k.Marshal())
 	return base64.StdEncoding.EncodeToString(bytes[:])
 }
+
+// LoadKey loads an SSH private key from a file, or from an inline
+// ChiselKey, in the formats accepted by chisel server --keyfile
+func LoadKey(keyFile string) (ssh.Signer, error) {
+	key := []byte(keyFile)
+	if !IsChiselKey(key) {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read key file %s", keyFile)
+		}
+		key = b
+	}
+	if IsChiselKey(key) {
+		pem, err := ChiselKey2PEM(key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key %s", keyFile)
+		}
+		key = pem
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse key %s: %s", keyFile, err)
+	}
+	return signer, nil
+}
+
+// LoadCertificate loads an SSH certificate, in the
+// format written by ssh-keygen -s (e.g. id_ed25519-cert.pub)
+func LoadCertificate(certFile string) (*ssh.Certificate, error) {
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read certificate file %s", certFile)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate %s: %s", certFile, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not a certificate", certFile)
+	}
+	return cert, nil
+}
+
+// LoadPublicKeys loads the SSH public keys of a file, one per
+// line in the authorized_keys format, ignoring comment lines
+func LoadPublicKeys(keysFile string) ([]ssh.PublicKey, error) {
+	b, err := os.ReadFile(keysFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read keys file %s", keysFile)
+	}
+	keys := []ssh.PublicKey{}
+	for n, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse keys file %s (line %d): %s", keysFile, n+1, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No keys found in %s", keysFile)
+	}
+	return keys, nil
+}
@@ -0,0 +1,135 @@
+package cio
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+//Limiter is a token bucket limiting the rate of the bytes
+//passing through it, with a burst of one second. Its rate
+//may be changed at any time. A nil Limiter is unlimited.
+type Limiter struct {
+	mut    sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+//NewLimiter creates a Limiter of the given bytes per
+//second, where 0 is unlimited
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+//SetRate changes the bytes per second of the Limiter,
+//where 0 is unlimited
+func (l *Limiter) SetRate(rate int64) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if l.rate <= 0 {
+		//refill, previously unlimited
+		l.tokens = float64(rate)
+		l.last = time.Now()
+	}
+	l.rate = rate
+}
+
+//Rate returns the bytes per second of the Limiter
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.rate
+}
+
+//Wait blocks until n bytes may pass. Waiting callers
+//take turns, by reserving the tokens they wait for.
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mut.Lock()
+	if l.rate <= 0 {
+		l.mut.Unlock()
+		return
+	}
+	rate := float64(l.rate)
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mut.Unlock()
+	time.Sleep(wait)
+}
+
+//limiters waits on each of its non-nil Limiters
+type limiters []*Limiter
+
+func newLimiters(ls []*Limiter) limiters {
+	out := limiters{}
+	for _, l := range ls {
+		if l != nil {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func (ls limiters) wait(n int) {
+	for _, l := range ls {
+		l.Wait(n)
+	}
+}
+
+//size caps reads to a tenth of a second of the slowest
+//limiter, so that limited streams flow smoothly
+func (ls limiters) size(n int) int {
+	for _, l := range ls {
+		if r := int(l.Rate() / 10); r > 0 && r < n {
+			n = r
+		}
+	}
+	if n < 512 {
+		n = 512
+	}
+	return n
+}
+
+//LimitRWC limits the bytes read from and written to rwc,
+//which is returned as is without any non-nil limiters
+func LimitRWC(rwc io.ReadWriteCloser, ls ...*Limiter) io.ReadWriteCloser {
+	l := newLimiters(ls)
+	if len(l) == 0 {
+		return rwc
+	}
+	return &limitedRWC{ReadWriteCloser: rwc, limiters: l}
+}
+
+type limitedRWC struct {
+	io.ReadWriteCloser
+	limiters limiters
+}
+
+func (c *limitedRWC) Read(p []byte) (int, error) {
+	if n := c.limiters.size(len(p)); n < len(p) {
+		p = p[:n]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	c.limiters.wait(n)
+	return n, err
+}
+
+func (c *limitedRWC) Write(p []byte) (int, error) {
+	c.limiters.wait(len(p))
+	return c.ReadWriteCloser.Write(p)
+}
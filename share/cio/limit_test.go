@@ -0,0 +1,33 @@
+package cio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(10000)
+	//the first second is a burst
+	t0 := time.Now()
+	l.Wait(10000)
+	if d := time.Since(t0); d > 50*time.Millisecond {
+		t.Fatalf("expected burst, waited %s", d)
+	}
+	l.Wait(2000)
+	if d := time.Since(t0); d < 150*time.Millisecond || d > 400*time.Millisecond {
+		t.Fatalf("expected to wait 200ms, waited %s", d)
+	}
+	//unlimited
+	l.SetRate(0)
+	t0 = time.Now()
+	l.Wait(1 << 30)
+	if d := time.Since(t0); d > 50*time.Millisecond {
+		t.Fatalf("expected no wait, waited %s", d)
+	}
+	//nil limiters are unlimited
+	var none *Limiter
+	none.Wait(1 << 30)
+	if none.Rate() != 0 {
+		t.Fatal("expected nil limiter to be unlimited")
+	}
+}
@@ -1,18 +1,33 @@
 package cio
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-//Logger is pkg/log Logger with prefixing and 2 log levels
+//LevelTrace is below slog.LevelDebug, for
+//logging the details of each connection
+const LevelTrace = slog.Level(-8)
+
+//Logger is a log/slog Logger with prefixing and 5 log levels:
+//trace, debug and info are enabled by their flags, while warn
+//and error are always enabled. Forks of a Logger share its
+//output, and the key/value fields added with With.
 type Logger struct {
-	Info, Debug bool
+	Info, Debug, Trace bool
 	//internal
-	prefix      string
-	logger      *log.Logger
-	info, debug *bool
+	prefix string
+	name   string
+	args   []any
+	parent *Logger
+	out    *output
 }
 
 func NewLogger(prefix string) *Logger {
@@ -22,23 +37,41 @@ func NewLogger(prefix string) *Logger {
 func NewLoggerFlag(prefix string, flag int) *Logger {
 	l := &Logger{
 		prefix: prefix,
-		logger: log.New(os.Stderr, "", flag),
+		name:   prefix,
+		out:    &output{min: LevelTrace, flag: flag},
 		Info:   false,
 		Debug:  false,
 	}
+	l.out.handler = newTextHandler(os.Stderr, flag)
 	return l
 }
 
+func (l *Logger) Tracef(f string, args ...interface{}) {
+	l.Logf(LevelTrace, f, args...)
+}
+
 func (l *Logger) Infof(f string, args ...interface{}) {
-	if l.IsInfo() {
-		l.logger.Printf(l.prefix+": "+f, args...)
-	}
+	l.Logf(slog.LevelInfo, f, args...)
 }
 
 func (l *Logger) Debugf(f string, args ...interface{}) {
-	if l.IsDebug() {
-		l.logger.Printf(l.prefix+": "+f, args...)
+	l.Logf(slog.LevelDebug, f, args...)
+}
+
+func (l *Logger) Warnf(f string, args ...interface{}) {
+	l.Logf(slog.LevelWarn, f, args...)
+}
+
+//Logf logs at the given level, use slog.LevelError
+//to log errors, as Errorf only returns them
+func (l *Logger) Logf(level slog.Level, f string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
 	}
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(f, args...), 0)
+	r.AddAttrs(slog.String(loggerKey, l.name), slog.String(prefixKey, l.prefix))
+	r.Add(l.args...)
+	l.out.handle(r)
 }
 
 func (l *Logger) Errorf(f string, args ...interface{}) error {
@@ -47,32 +80,228 @@ func (l *Logger) Errorf(f string, args ...interface{}) error {
 
 func (l *Logger) Fork(prefix string, args ...interface{}) *Logger {
 	//slip the parent prefix at the front
-	args = append([]interface{}{l.prefix}, args...)
-	ll := NewLogger(fmt.Sprintf("%s: "+prefix, args...))
-	//store link to parent settings too
-	ll.Info = l.Info
-	if l.info != nil {
-		ll.info = l.info
-	} else {
-		ll.info = &l.Info
-	}
-	ll.Debug = l.Debug
-	if l.debug != nil {
-		ll.debug = l.debug
-	} else {
-		ll.debug = &l.Debug
-	}
+	p := fmt.Sprintf(prefix, args...)
+	return l.fork(l.prefix+": "+p, l.name+": "+p)
+}
+
+//ForkID forks the Logger for an instance of a component,
+//such as ForkID("session", id), which prefixes text logs
+//with session#id and adds the session field to records,
+//while their logger field remains the component name
+func (l *Logger) ForkID(name string, id any) *Logger {
+	ll := l.fork(fmt.Sprintf("%s: %s#%v", l.prefix, name, id), l.name+": "+name)
+	ll.args = append(ll.args, name, id)
 	return ll
 }
 
+//With forks the Logger, adding key/value fields
+//to its records, such as With("user", name)
+func (l *Logger) With(args ...any) *Logger {
+	ll := l.fork(l.prefix, l.name)
+	ll.args = append(ll.args, args...)
+	return ll
+}
+
+func (l *Logger) fork(prefix, name string) *Logger {
+	//store link to parent settings and output too
+	return &Logger{
+		Info:   l.Info,
+		Debug:  l.Debug,
+		Trace:  l.Trace,
+		prefix: prefix,
+		name:   name,
+		args:   append([]any{}, l.args...),
+		parent: l,
+		out:    l.out,
+	}
+}
+
 func (l *Logger) Prefix() string {
 	return l.prefix
 }
 
 func (l *Logger) IsInfo() bool {
-	return l.Info || (l.info != nil && *l.info)
+	return l.Info || (l.parent != nil && l.parent.IsInfo())
 }
 
 func (l *Logger) IsDebug() bool {
-	return l.Debug || (l.debug != nil && *l.debug)
+	return l.Debug || (l.parent != nil && l.parent.IsDebug())
+}
+
+func (l *Logger) IsTrace() bool {
+	return l.Trace || (l.parent != nil && l.parent.IsTrace())
+}
+
+//Enabled returns whether records of the level are logged
+func (l *Logger) Enabled(level slog.Level) bool {
+	if level < l.out.level() {
+		return false
+	}
+	switch {
+	case level >= slog.LevelWarn:
+		return true
+	case level >= slog.LevelInfo:
+		return l.IsInfo()
+	case level >= slog.LevelDebug:
+		return l.IsDebug()
+	default:
+		return l.IsTrace()
+	}
+}
+
+//SetLevel enables the named level (trace, debug, info, warn
+//or error) and those above it, for the Logger and its forks.
+//The level is also the minimum of the shared output.
+func (l *Logger) SetLevel(name string) error {
+	level, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	l.Trace = level <= LevelTrace
+	l.Debug = level <= slog.LevelDebug
+	l.Info = level <= slog.LevelInfo
+	l.out.setLevel(level)
+	return nil
+}
+
+//SetFormat sets the shared output of the Logger
+//and its forks to text (the default) or json
+func (l *Logger) SetFormat(format string) error {
+	return l.SetOutput(os.Stderr, format)
+}
+
+//SetOutput sets the shared output of the Logger and its forks
+func (l *Logger) SetOutput(w io.Writer, format string) error {
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = newTextHandler(w, l.out.flag)
+	case "json":
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level:       LevelTrace,
+			ReplaceAttr: replaceAttr,
+		})
+	default:
+		return fmt.Errorf("Invalid log format %q", format)
+	}
+	l.out.setHandler(h)
+	return nil
+}
+
+//Configure sets the level and format of the Logger,
+//the current level is kept when level is empty
+func (l *Logger) Configure(level, format string) error {
+	if level != "" {
+		if err := l.SetLevel(level); err != nil {
+			return err
+		}
+	}
+	return l.SetFormat(format)
+}
+
+//ParseLevel parses the name of a log level
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "trace") {
+		return LevelTrace, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("Invalid log level %q", name)
+	}
+	return level, nil
+}
+
+//loggerKey is the field holding the component names
+//of the Logger, and prefixKey its prefix with the ids
+//of ForkID, which only the text handler prints
+const (
+	loggerKey = "logger"
+	prefixKey = "prefix"
+)
+
+//output is shared by a Logger and its forks
+type output struct {
+	mut     sync.RWMutex
+	handler slog.Handler
+	min     slog.Level
+	flag    int
+}
+
+func (o *output) handle(r slog.Record) {
+	o.mut.RLock()
+	h := o.handler
+	o.mut.RUnlock()
+	h.Handle(context.Background(), r)
+}
+
+func (o *output) level() slog.Level {
+	o.mut.RLock()
+	defer o.mut.RUnlock()
+	return o.min
+}
+
+func (o *output) setLevel(level slog.Level) {
+	o.mut.Lock()
+	o.min = level
+	o.mut.Unlock()
+}
+
+func (o *output) setHandler(h slog.Handler) {
+	o.mut.Lock()
+	o.handler = h
+	o.mut.Unlock()
+}
+
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == prefixKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+//textHandler keeps the classic "prefix: message" log
+//lines, as the prefix already holds the ids of the
+//session and connection, other fields are left out
+type textHandler struct {
+	logger *log.Logger
+}
+
+func newTextHandler(w io.Writer, flag int) *textHandler {
+	return &textHandler{logger: log.New(w, "", flag)}
+}
+
+func (h *textHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	prefix := ""
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == prefixKey {
+			prefix = a.Value.String()
+			return false
+		}
+		return true
+	})
+	msg := r.Message
+	if r.Level >= slog.LevelWarn {
+		msg = r.Level.String() + " " + msg
+	}
+	if prefix != "" {
+		msg = prefix + ": " + msg
+	}
+	return h.logger.Output(2, msg)
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
 }
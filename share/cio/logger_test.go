@@ -0,0 +1,77 @@
+package cio
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := NewLogger("server")
+	l.Info = true
+	if err := l.SetOutput(b, "json"); err != nil {
+		t.Fatal(err)
+	}
+	c := l.ForkID("session", 1).With("user", "foo")
+	c.Debugf("hidden")
+	c.Infof("hello %s", "world")
+	c.Warnf("careful")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]any{
+		"level":   "INFO",
+		"msg":     "hello world",
+		"logger":  "server: session",
+		"session": float64(1),
+		"user":    "foo",
+	} {
+		if m[k] != v {
+			t.Fatalf("expected %s=%v, got %v", k, v, m[k])
+		}
+	}
+	if _, ok := m[prefixKey]; ok {
+		t.Fatalf("unexpected prefix %v", m[prefixKey])
+	}
+	//fields of forks are not shared with their parents
+	b.Reset()
+	l.Infof("parent")
+	if strings.Contains(b.String(), "user") {
+		t.Fatalf("unexpected fields %s", b.String())
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := NewLoggerFlag("client", 0)
+	l.SetOutput(b, "text")
+	c := l.ForkID("conn", 2)
+	//forks follow the levels of their parents
+	if err := l.SetLevel("trace"); err != nil {
+		t.Fatal(err)
+	}
+	c.Tracef("trace")
+	if err := l.SetLevel("error"); err != nil {
+		t.Fatal(err)
+	}
+	c.Infof("info")
+	c.Warnf("warn")
+	c.Logf(slog.LevelError, "error")
+	if got, expect := b.String(), "client: conn#2: trace\nclient: conn#2: ERROR error\n"; got != expect {
+		t.Fatalf("expected %q, got %q", expect, got)
+	}
+	if err := l.SetLevel("loud"); err == nil {
+		t.Fatal("expected an invalid level")
+	}
+	if err := l.SetFormat("xml"); err == nil {
+		t.Fatal("expected an invalid format")
+	}
+}
@@ -6,25 +6,19 @@ import (
 	"math/rand"
 	"sync"
 	"time"
-)
 
-// Chunk size range for packet chunking (default: 1KB-32KB, highest level)
-const (
-	chunkSizeMin = 1 * 1024  // 1KB
-	chunkSizeMax = 32 * 1024 // 32KB
-	chunkDelayMin = 0 * time.Millisecond
-	chunkDelayMax = 20 * time.Millisecond
+	"github.com/jpillora/chisel/share/traffic"
 )
 
 // chunkedCopy copies data in randomized chunks to simulate real network behavior
-func chunkedCopy(dst io.Writer, src io.Reader, rng *rand.Rand) (int64, error) {
-	buf := make([]byte, chunkSizeMax)
+func chunkedCopy(dst io.Writer, src io.Reader, o traffic.Obfuscation, rng *rand.Rand) (int64, error) {
+	buf := make([]byte, o.ChunkSizeMax)
 	var total int64
-	
+
 	for {
-		// Determine chunk size: random between min and max (normal distribution approximation)
-		chunkSize := chunkSizeMin + rng.Intn(chunkSizeMax-chunkSizeMin+1)
-		
+		// Determine chunk size: random between min and max
+		chunkSize := o.ChunkSizeMin + rng.Intn(o.ChunkSizeMax-o.ChunkSizeMin+1)
+
 		nr, er := src.Read(buf[:chunkSize])
 		if nr > 0 {
 			nw, ew := dst.Write(buf[0:nr])
@@ -41,14 +35,15 @@ func chunkedCopy(dst io.Writer, src io.Reader, rng *rand.Rand) (int64, error) {
 			if nr != nw {
 				return total, io.ErrShortWrite
 			}
-			
-			// Add random delay between chunks (0-20ms, highest level)
-			delayRange := chunkDelayMax - chunkDelayMin
+
+			// Add random delay between chunks
+			delayRange := o.ChunkDelayMax - o.ChunkDelayMin
+			delay := o.ChunkDelayMin
 			if delayRange > 0 {
-				delay := time.Duration(rng.Int63n(int64(delayRange))) + chunkDelayMin
-				if delay > 0 {
-					time.Sleep(delay)
-				}
+				delay += time.Duration(rng.Int63n(int64(delayRange)))
+			}
+			if delay > 0 {
+				time.Sleep(delay)
 			}
 		}
 		if er != nil {
@@ -61,29 +56,41 @@ func chunkedCopy(dst io.Writer, src io.Reader, rng *rand.Rand) (int64, error) {
 	return total, nil
 }
 
+//Pipe copies data between src and dst until either
+//side closes, using the highest obfuscation level
 func Pipe(src io.ReadWriteCloser, dst io.ReadWriteCloser) (int64, int64) {
+	return PipeWith(src, dst, traffic.ObfuscationHigh)
+}
+
+//PipeWith is Pipe using the given obfuscation, without
+//chunking it is a plain io.Copy in both directions. Data in
+//both directions is limited by the given Limiters, if any.
+func PipeWith(src io.ReadWriteCloser, dst io.ReadWriteCloser, o traffic.Obfuscation, ls ...*Limiter) (int64, int64) {
+	src = LimitRWC(src, ls...)
 	var sent, received int64
 	var wg sync.WaitGroup
-	var o sync.Once
+	var once sync.Once
 	close := func() {
 		src.Close()
 		dst.Close()
 	}
-	
-	// Use randomized chunking for more realistic traffic patterns
-	// Default: enabled at highest level
-	rng1 := rand.New(rand.NewSource(time.Now().UnixNano()))
-	rng2 := rand.New(rand.NewSource(time.Now().UnixNano() + 1))
-	
+	cp := func(dst io.Writer, src io.Reader, seed int64) (int64, error) {
+		if o.ChunkSizeMax == 0 {
+			return io.Copy(dst, src)
+		}
+		// Use randomized chunking for more realistic traffic patterns
+		rng := rand.New(rand.NewSource(time.Now().UnixNano() + seed))
+		return chunkedCopy(dst, src, o, rng)
+	}
 	wg.Add(2)
 	go func() {
-		received, _ = chunkedCopy(src, dst, rng1)
-		o.Do(close)
+		received, _ = cp(src, dst, 0)
+		once.Do(close)
 		wg.Done()
 	}()
 	go func() {
-		sent, _ = chunkedCopy(dst, src, rng2)
-		o.Do(close)
+		sent, _ = cp(dst, src, 1)
+		once.Do(close)
 		wg.Done()
 	}()
 	wg.Wait()
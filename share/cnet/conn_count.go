@@ -0,0 +1,40 @@
+package cnet
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+//CountConn is a net.Conn which counts
+//the bytes read and written
+type CountConn struct {
+	net.Conn
+	sent, recv atomic.Int64
+}
+
+//NewCountConn wraps the given net.Conn
+func NewCountConn(conn net.Conn) *CountConn {
+	return &CountConn{Conn: conn}
+}
+
+func (c *CountConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.recv.Add(int64(n))
+	return n, err
+}
+
+func (c *CountConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+//Sent returns the number of bytes written
+func (c *CountConn) Sent() int64 {
+	return c.sent.Load()
+}
+
+//Received returns the number of bytes read
+func (c *CountConn) Received() int64 {
+	return c.recv.Load()
+}
@@ -0,0 +1,56 @@
+package cnet
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/jpillora/chisel/share/traffic"
+)
+
+//obfsConn delays the writes of a net.Conn
+type obfsConn struct {
+	net.Conn
+	rng *rand.Rand
+	// Write delay range
+	delayMin, delayMax time.Duration
+}
+
+//NewObfuscatedConn delays the writes of a net.Conn according to
+//the given obfuscation, so that the connections of all transports
+//are shaped alike. Without write delays, the conn is returned as is.
+func NewObfuscatedConn(c net.Conn, o traffic.Obfuscation) net.Conn {
+	if o.WriteDelayMax <= 0 {
+		return c
+	}
+	return &obfsConn{
+		Conn:     c,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		delayMin: o.WriteDelayMin,
+		delayMax: o.WriteDelayMax,
+	}
+}
+
+func (c *obfsConn) Write(b []byte) (int, error) {
+	// Add randomized delay to make packet timing less predictable
+	// Delay is proportional to packet size to simulate real network behavior
+	// Base delay: random between min and max
+	baseDelay := c.delayMin
+	if delayRange := c.delayMax - c.delayMin; delayRange > 0 {
+		baseDelay += time.Duration(c.rng.Int63n(int64(delayRange)))
+	}
+	// Add small additional delay based on packet size (larger packets = slightly more delay)
+	// Scale factor: 0-10% of base delay based on packet size (max 64KB)
+	maxPacketSize := 64 * 1024
+	sizeFactor := float64(len(b)) / float64(maxPacketSize)
+	if sizeFactor > 1.0 {
+		sizeFactor = 1.0
+	}
+	additionalDelay := time.Duration(float64(baseDelay) * sizeFactor * 0.1)
+	totalDelay := baseDelay + additionalDelay
+	// Only add delay if it's significant (> 1ms) to avoid unnecessary overhead
+	if totalDelay > 1*time.Millisecond {
+		time.Sleep(totalDelay)
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,532 @@
+package cnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//PollHeader carries the session of the HTTP polling
+//transport, for proxies which strip websocket upgrades.
+//Sessions are opened with a POST of the value "open",
+//then data is sent with POSTs and received with GETs.
+const PollHeader = "X-Poll-Session"
+
+const (
+	pollOpen = "open"
+	//pollOffsetHeader carries the offset of the data of
+	//a POST, which is replied with the offset received,
+	//and the offset received by a GET, which acknowledges
+	//the data before it. Data is kept until acknowledged,
+	//so that lost requests are retried.
+	pollOffsetHeader = "X-Poll-Offset"
+	//writes block while this much is unacknowledged
+	maxPollBuffer = 1 << 20
+	//requests are retried this many times in a row
+	maxPollRetries = 3
+	pollRetryDelay = 250 * time.Millisecond
+)
+
+var errPollOffset = errors.New("HTTP polling lost data")
+
+//IsPoll returns whether the request belongs to the
+//HTTP polling transport
+func IsPoll(r *http.Request) bool {
+	return r.Header.Get(PollHeader) != ""
+}
+
+//pollConn is a net.Conn carried by HTTP requests, reading
+//from the bodies of one direction and buffering writes
+//until they are polled by the other
+type pollConn struct {
+	in      *io.PipeReader
+	inw     *io.PipeWriter
+	out     *pollBuffer
+	local   net.Addr
+	remote  net.Addr
+	once    sync.Once
+	onClose func()
+}
+
+func newPollConn(local, remote string) *pollConn {
+	in, inw := io.Pipe()
+	return &pollConn{
+		in:     in,
+		inw:    inw,
+		out:    newPollBuffer(),
+		local:  httpAddr(local),
+		remote: httpAddr(remote),
+	}
+}
+
+func (c *pollConn) Read(b []byte) (int, error) {
+	return c.in.Read(b)
+}
+
+func (c *pollConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func (c *pollConn) Close() error {
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+//fail closes the conn, reads and writes return the error
+func (c *pollConn) fail(err error) {
+	c.once.Do(func() {
+		c.inw.CloseWithError(err)
+		c.out.close(err)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+}
+
+func (c *pollConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *pollConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *pollConn) SetDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *pollConn) SetReadDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *pollConn) SetWriteDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+type httpAddr string
+
+func (a httpAddr) Network() string {
+	return "tcp"
+}
+
+func (a httpAddr) String() string {
+	return string(a)
+}
+
+//pollBuffer holds written data until the offset past it
+//is acknowledged, blocking writers while full. Offsets
+//count the bytes written to the buffer.
+type pollBuffer struct {
+	mut  sync.Mutex
+	cond *sync.Cond
+	data []byte
+	//base is the offset of data
+	base  int64
+	err   error
+	ready chan struct{}
+}
+
+func newPollBuffer() *pollBuffer {
+	b := &pollBuffer{ready: make(chan struct{}, 1)}
+	b.cond = sync.NewCond(&b.mut)
+	return b
+}
+
+func (b *pollBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for len(b.data) >= maxPollBuffer && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.data = append(b.data, p...)
+	b.signal()
+	return len(p), nil
+}
+
+func (b *pollBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+//read acknowledges the data before the offset and waits
+//for the data after it, returning nothing once the context
+//is done or for offsets already acknowledged, which belong
+//to abandoned requests, and the error once closed and drained
+func (b *pollBuffer) read(ctx context.Context, off int64) ([]byte, error) {
+	for {
+		b.mut.Lock()
+		if off < b.base {
+			b.mut.Unlock()
+			return nil, nil
+		}
+		if off > b.base+int64(len(b.data)) {
+			b.mut.Unlock()
+			return nil, errPollOffset
+		}
+		if n := off - b.base; n > 0 {
+			b.data = b.data[n:]
+			b.base = off
+			b.cond.Broadcast()
+		}
+		//appends never overwrite the returned data
+		data, err := b.data, b.err
+		b.mut.Unlock()
+		if len(data) > 0 {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-b.ready:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+func (b *pollBuffer) close(err error) {
+	b.mut.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+	b.signal()
+	b.mut.Unlock()
+}
+
+//pollClient drives the client side of a polling session
+type pollClient struct {
+	*pollConn
+	client *http.Client
+	url    string
+	header http.Header
+	id     string
+	ctx    context.Context
+}
+
+//DialPoll opens a session of the HTTP polling transport at the
+//url, sending the headers with each of its requests. The conn
+//polls until closed, independent of the context.
+func DialPoll(ctx context.Context, client *http.Client, url string, header http.Header) (net.Conn, error) {
+	p := &pollClient{
+		client: client,
+		url:    url,
+		header: header,
+		id:     pollOpen,
+	}
+	resp, err := p.request(ctx, "POST", 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	p.id = resp.Header.Get(PollHeader)
+	if p.id == "" {
+		return nil, fmt.Errorf("HTTP polling not supported by server")
+	}
+	pctx, cancel := context.WithCancel(context.Background())
+	p.ctx = pctx
+	p.pollConn = newPollConn("poll", resp.Request.URL.Host)
+	p.onClose = func() {
+		cancel()
+		//tell the server, best effort
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if resp, err := p.request(ctx, "DELETE", 0, nil); err == nil {
+				resp.Body.Close()
+			}
+			p.client.CloseIdleConnections()
+		}()
+	}
+	go p.recv()
+	go p.send()
+	return p, nil
+}
+
+func (p *pollClient) request(ctx context.Context, method string, off int64, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = p.header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Set(PollHeader, p.id)
+	req.Header.Set(pollOffsetHeader, strconv.FormatInt(off, 10))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, io.EOF
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP polling failed (%s)", resp.Status)
+	}
+	return resp, nil
+}
+
+//recv polls the server, piping responses to reads,
+//from the offset read so far
+func (p *pollClient) recv() {
+	var off int64
+	failures := 0
+	for {
+		resp, err := p.request(p.ctx, "GET", off, nil)
+		if err == nil {
+			var n int64
+			n, err = io.Copy(p.inw, resp.Body)
+			resp.Body.Close()
+			off += n
+		}
+		if err != nil {
+			if !p.retry(err, &failures) {
+				p.fail(err)
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+//send posts buffered writes to the server, from
+//the offset it acknowledged so far
+func (p *pollClient) send() {
+	var off int64
+	failures := 0
+	for {
+		b, err := p.out.read(p.ctx, off)
+		if err != nil || b == nil {
+			return
+		}
+		resp, err := p.request(p.ctx, "POST", off, b)
+		if err == nil {
+			resp.Body.Close()
+			var ack int64
+			ack, err = strconv.ParseInt(resp.Header.Get(pollOffsetHeader), 10, 64)
+			if err == nil && (ack < off || ack > off+int64(len(b))) {
+				err = errPollOffset
+			}
+			if err == nil {
+				off = ack
+			}
+		}
+		if err != nil {
+			if !p.retry(err, &failures) {
+				p.fail(err)
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+//retry returns whether to retry a failed request, after a
+//delay, unless the session is gone or keeps failing
+func (p *pollClient) retry(err error, failures *int) bool {
+	if err == io.EOF || err == errPollOffset || p.ctx.Err() != nil {
+		return false
+	}
+	*failures++
+	if *failures > maxPollRetries {
+		return false
+	}
+	select {
+	case <-time.After(time.Duration(*failures) * pollRetryDelay):
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+//PollServer serves the sessions of the HTTP polling
+//transport, handing each new one to the handler
+type PollServer struct {
+	timeout time.Duration
+	handle  func(net.Conn)
+	mut     sync.Mutex
+	conns   map[string]*pollSession
+}
+
+type pollSession struct {
+	*pollConn
+	idle    *time.Timer
+	idleMut sync.Mutex
+	//requests in flight, which hold the idle timer
+	requests int
+	inMut    sync.Mutex
+	//inOff is the offset received so far
+	inOff int64
+}
+
+//NewPollServer creates a PollServer, which holds polls for up to
+//the timeout while there is nothing to send, closing sessions
+//without requests in flight for twice as long. The conn of a
+//session is closed once the handler returns.
+func NewPollServer(timeout time.Duration, handle func(net.Conn)) *PollServer {
+	return &PollServer{
+		timeout: timeout,
+		handle:  handle,
+		conns:   map[string]*pollSession{},
+	}
+}
+
+func (p *PollServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	id := r.Header.Get(PollHeader)
+	if id == pollOpen && r.Method == "POST" {
+		p.open(w, r)
+		return
+	}
+	p.mut.Lock()
+	s, ok := p.conns[id]
+	p.mut.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	defer s.busy(2 * p.timeout)()
+	off, err := strconv.ParseInt(r.Header.Get(pollOffsetHeader), 10, 64)
+	if err != nil && (r.Method == "GET" || r.Method == "POST") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.poll(w, r, off, p.timeout)
+	case "POST":
+		s.push(w, r, off)
+	case "DELETE":
+		s.Close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//Close closes the conns of all sessions
+func (p *PollServer) Close() error {
+	p.mut.Lock()
+	sessions := make([]*pollSession, 0, len(p.conns))
+	for _, s := range p.conns {
+		sessions = append(sessions, s)
+	}
+	p.mut.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+	return nil
+}
+
+func (p *PollServer) open(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b)
+	s := &pollSession{pollConn: newPollConn(r.Host, r.RemoteAddr)}
+	s.idle = time.AfterFunc(2*p.timeout, func() {
+		s.fail(io.EOF)
+	})
+	s.onClose = func() {
+		s.idle.Stop()
+		p.mut.Lock()
+		delete(p.conns, id)
+		p.mut.Unlock()
+	}
+	p.mut.Lock()
+	p.conns[id] = s
+	p.mut.Unlock()
+	go func() {
+		p.handle(s.pollConn)
+		s.Close()
+	}()
+	w.Header().Set(PollHeader, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//busy stops the idle timer until the returned
+//func is called by each request in flight
+func (s *pollSession) busy(idle time.Duration) func() {
+	s.idleMut.Lock()
+	s.requests++
+	s.idle.Stop()
+	s.idleMut.Unlock()
+	return func() {
+		s.idleMut.Lock()
+		s.requests--
+		if s.requests == 0 {
+			s.idle.Reset(idle)
+		}
+		s.idleMut.Unlock()
+	}
+}
+
+//poll responds with the buffered writes of the session
+//after the offset, which the client received
+func (s *pollSession) poll(w http.ResponseWriter, r *http.Request, off int64, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	b, err := s.out.read(ctx, off)
+	if err != nil {
+		if err == errPollOffset {
+			s.fail(err)
+		}
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	//lost responses are polled again
+	w.Write(b)
+}
+
+//push pipes the body of the request to the reads of the
+//session, skipping the data it received before, and
+//responds with the offset received
+func (s *pollSession) push(w http.ResponseWriter, r *http.Request, off int64) {
+	s.inMut.Lock()
+	defer s.inMut.Unlock()
+	if off > s.inOff {
+		s.fail(errPollOffset)
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	//partial bodies are acknowledged as far as they go
+	b, _ := io.ReadAll(r.Body)
+	if skip := s.inOff - off; skip < int64(len(b)) {
+		n, err := s.inw.Write(b[skip:])
+		s.inOff += int64(n)
+		if err != nil {
+			s.fail(err)
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+	}
+	w.Header().Set(pollOffsetHeader, strconv.FormatInt(s.inOff, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
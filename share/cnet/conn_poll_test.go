@@ -0,0 +1,113 @@
+package cnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollLostResponses(t *testing.T) {
+	p := NewPollServer(time.Second, func(c net.Conn) {
+		io.Copy(c, c)
+	})
+	defer p.Close()
+	//a proxy which loses every other response,
+	//after the server handled the request
+	var mut sync.Mutex
+	requests := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		mut.Lock()
+		requests++
+		lose := requests%2 == 0 && r.Method != "DELETE"
+		mut.Unlock()
+		if lose {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer proxy.Close()
+	c, err := DialPoll(context.Background(), proxy.Client(), proxy.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	sent := make([]byte, 1<<18)
+	rand.Read(sent)
+	go func() {
+		//many writes, to span many requests
+		for b := sent; len(b) > 0; b = b[min(len(b), 4096):] {
+			if _, err := c.Write(b[:min(len(b), 4096)]); err != nil {
+				return
+			}
+		}
+	}()
+	received := make([]byte, len(sent))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(c, received)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the echo of the data sent, timed out")
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("expected the echo of the data sent")
+	}
+}
+
+func TestPollIdleWhileBusy(t *testing.T) {
+	received := make(chan string, 1)
+	p := NewPollServer(50*time.Millisecond, func(c net.Conn) {
+		//the post blocks for longer than the idle timeout
+		time.Sleep(300 * time.Millisecond)
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(c, b); err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(b)
+	})
+	defer p.Close()
+	server := httptest.NewServer(p)
+	defer server.Close()
+	request := func(id, offset, body string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader(body))
+		req.Header.Set(PollHeader, id)
+		req.Header.Set(pollOffsetHeader, offset)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	id := request(pollOpen, "0", "").Header.Get(PollHeader)
+	//no polls, only the post in flight
+	resp := request(id, "0", "foo")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(pollOffsetHeader) != "3" {
+		t.Fatalf("expected the post to be received, got %s", resp.Status)
+	}
+	if got := <-received; got != "foo" {
+		t.Fatalf("expected foo, got %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+package cnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//StreamHeader marks the requests of the HTTP/2 stream
+//transport, which carries the connection in the bodies
+//of a request and its response
+const StreamHeader = "X-Stream"
+
+//StreamProtocol is the :protocol of the extended CONNECT
+//requests (RFC 8441) of the HTTP/2 stream transport
+const StreamProtocol = "bytestream"
+
+//IsStream returns whether the request belongs to the
+//HTTP/2 stream transport
+func IsStream(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Header.Get(StreamHeader) != ""
+}
+
+//streamConn is a net.Conn over the bodies of an HTTP/2
+//request and its response, flushing each write
+type streamConn struct {
+	io.Reader
+	w       io.Writer
+	flush   func() error
+	local   net.Addr
+	remote  net.Addr
+	once    sync.Once
+	onClose func()
+	mut     sync.Mutex
+	closed  atomic.Bool
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.Reader.Read(b)
+	if err != nil && c.closed.Load() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(b)
+	if err == nil && c.flush != nil {
+		err = c.flush()
+	}
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	c.once.Do(func() {
+		c.closed.Store(true)
+		c.onClose()
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+//ServeStream responds to a request of the HTTP/2 stream
+//transport, handing the conn of the stream to the handler,
+//and returns once the handler has and the conn is closed
+func ServeStream(w http.ResponseWriter, r *http.Request, handle func(net.Conn)) error {
+	if !IsStream(r) {
+		return errors.New("not an HTTP/2 stream")
+	}
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+	c := &streamConn{
+		Reader:  r.Body,
+		w:       w,
+		flush:   rc.Flush,
+		local:   httpAddr(r.Host),
+		remote:  httpAddr(r.RemoteAddr),
+		onClose: func() { r.Body.Close() },
+	}
+	handle(c)
+	c.Close()
+	//the response is not written once the request returns
+	c.mut.Lock()
+	c.mut.Unlock()
+	return nil
+}
+
+//DialStream opens an HTTP/2 stream at the url with the round
+//tripper, which must speak HTTP/2. Extended CONNECT is used
+//when the server supports it, otherwise a POST. The stream
+//is open until closed, independent of the context.
+func DialStream(ctx context.Context, rt http.RoundTripper, url string, header http.Header) (net.Conn, error) {
+	c, err := dialStream(ctx, rt, "CONNECT", url, header)
+	if err != nil && strings.Contains(err.Error(), "extended connect not supported") {
+		c, err = dialStream(ctx, rt, "POST", url, header)
+	}
+	return c, err
+}
+
+func dialStream(ctx context.Context, rt http.RoundTripper, method, url string, header http.Header) (net.Conn, error) {
+	sctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(sctx, method, url, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header = header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Set(StreamHeader, "1")
+	if method == "CONNECT" {
+		req.Header.Set(":protocol", StreamProtocol)
+	}
+	//the response arrives once the server accepts the stream
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := rt.RoundTrip(req)
+		done <- result{resp, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		cancel()
+		pw.Close()
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		cancel()
+		pw.Close()
+		return nil, res.err
+	}
+	if res.resp.StatusCode != http.StatusOK {
+		res.resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, fmt.Errorf("HTTP/2 stream refused (%s)", res.resp.Status)
+	}
+	return &streamConn{
+		Reader: res.resp.Body,
+		w:      pw,
+		local:  httpAddr("h2"),
+		remote: httpAddr(req.URL.Host),
+		onClose: func() {
+			pw.Close()
+			res.resp.Body.Close()
+			cancel()
+		},
+	}, nil
+}
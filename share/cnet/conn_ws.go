@@ -1,37 +1,36 @@
 package cnet
 
 import (
-	"math/rand"
 	"net"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jpillora/chisel/share/traffic"
 )
 
 type wsConn struct {
 	*websocket.Conn
 	buff []byte
-	rng  *rand.Rand
 	// Buffer management
 	readBufSize  int
 	writeBufSize int
 }
 
-var (
-	// Default packet delay range: 0-100ms (highest level)
-	packetDelayMin = 0 * time.Millisecond
-	packetDelayMax = 100 * time.Millisecond
-)
-
-//NewWebSocketConn converts a websocket.Conn into a net.Conn
+//NewWebSocketConn converts a websocket.Conn into a net.Conn,
+//using the highest obfuscation level
 func NewWebSocketConn(websocketConn *websocket.Conn) net.Conn {
+	return NewWebSocketConnWith(websocketConn, traffic.ObfuscationHigh)
+}
+
+//NewWebSocketConnWith converts a websocket.Conn into a net.Conn,
+//delaying writes according to the given obfuscation
+func NewWebSocketConnWith(websocketConn *websocket.Conn, o traffic.Obfuscation) net.Conn {
 	c := wsConn{
 		Conn:         websocketConn,
-		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
 		readBufSize:  32 * 1024,  // 32KB read buffer
 		writeBufSize: 64 * 1024,  // 64KB write buffer
 	}
-	return &c
+	return NewObfuscatedConn(&c, o)
 }
 
 //Read is not threadsafe though thats okay since there
@@ -77,29 +76,6 @@ func (c *wsConn) Read(dst []byte) (int, error) {
 }
 
 func (c *wsConn) Write(b []byte) (int, error) {
-	// Add randomized delay to make packet timing less predictable
-	// Default: 0-100ms delay (highest level)
-	// Delay is proportional to packet size to simulate real network behavior
-	delayRange := packetDelayMax - packetDelayMin
-	if delayRange > 0 {
-		// Base delay: random between min and max
-		baseDelay := time.Duration(c.rng.Int63n(int64(delayRange))) + packetDelayMin
-		// Add small additional delay based on packet size (larger packets = slightly more delay)
-		// Scale factor: 0-10% of base delay based on packet size (max 64KB)
-		maxPacketSize := 64 * 1024
-		sizeFactor := float64(len(b)) / float64(maxPacketSize)
-		if sizeFactor > 1.0 {
-			sizeFactor = 1.0
-		}
-		additionalDelay := time.Duration(float64(baseDelay) * sizeFactor * 0.1)
-		totalDelay := baseDelay + additionalDelay
-		
-		// Only add delay if it's significant (> 1ms) to avoid unnecessary overhead
-		if totalDelay > 1*time.Millisecond {
-			time.Sleep(totalDelay)
-		}
-	}
-	
 	// Chunk large writes for better performance and reliability
 	maxChunkSize := c.writeBufSize
 	if len(b) > maxChunkSize {
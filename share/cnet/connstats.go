@@ -23,6 +23,11 @@ func (c *ConnCount) Close() {
 	atomic.AddInt32(&c.open, -1)
 }
 
+//Load returns the open and total connection counts
+func (c *ConnCount) Load() (open, total int32) {
+	return atomic.LoadInt32(&c.open), atomic.LoadInt32(&c.count)
+}
+
 func (c *ConnCount) String() string {
 	return fmt.Sprintf("[%d/%d]", atomic.LoadInt32(&c.open), atomic.LoadInt32(&c.count))
 }
@@ -0,0 +1,207 @@
+// Package control implements the local control socket of chisel.
+//
+// The socket is a Unix domain socket speaking a line based JSON
+// protocol. Each request is a single line object naming a command:
+//
+//	{"command":"status"}
+//
+// and is answered with a single line object, holding either the
+// result of the command or an error:
+//
+//	{"ok":true,"result":{...}}
+//	{"ok":false,"error":"unknown command"}
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is a command sent to the control socket
+type Request struct {
+	Command string `json:"command"`
+}
+
+// Response is the reply to a Request
+type Response struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Handlers maps commands to functions returning their result
+type Handlers map[string]func() (interface{}, error)
+
+// Listen creates the control socket at the given path. A socket
+// left behind by a process which is no longer running is replaced,
+// whereas one that is still in use, or a file which is not a socket,
+// is an error.
+func Listen(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("control socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	//the socket exposes the state of the tunnel, so it is
+	//made private inside a private directory, and only then
+	//moved to its path
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".chisel-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &listener{UnixListener: ul, path: path}, nil
+}
+
+// listener removes its socket once closed
+type listener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *listener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
+
+// Serve answers requests on the listener until the context is
+// cancelled, after which the listener is closed
+func Serve(ctx context.Context, l net.Listener, h Handlers) {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go h.serveConn(ctx, c)
+	}
+}
+
+func (h Handlers) serveConn(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() {
+		c.Close()
+	})
+	defer stop()
+	s := bufio.NewScanner(c)
+	enc := json.NewEncoder(c)
+	enc.SetEscapeHTML(false)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		if err := enc.Encode(h.handle(s.Bytes())); err != nil {
+			return
+		}
+	}
+}
+
+func (h Handlers) handle(line []byte) Response {
+	req := Request{}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return Response{Error: "invalid request"}
+	}
+	fn, ok := h[req.Command]
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command '%s', expected one of: %s", req.Command, h.commands())}
+	}
+	result, err := fn()
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) //remotes contain =>
+	if err := enc.Encode(result); err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{OK: true, Result: bytes.TrimSpace(buf.Bytes())}
+}
+
+func (h Handlers) commands() string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Query sends the command to the control socket at
+// the given path and decodes its result into v
+func Query(path, command string, v interface{}) error {
+	c, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewEncoder(c).Encode(Request{Command: command}); err != nil {
+		return err
+	}
+	resp := Response{}
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return json.Unmarshal(resp.Result, v)
+}
+
+// Runtime describes the resource usage of the process
+type Runtime struct {
+	Goroutines int    `json:"goroutines"`
+	Memory     uint64 `json:"memory"`
+}
+
+// ReadRuntime returns the current resource usage
+func ReadRuntime() Runtime {
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	return Runtime{
+		Goroutines: runtime.NumGoroutine(),
+		Memory:     m.Alloc,
+	}
+}
@@ -0,0 +1,120 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chisel.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Serve(ctx, l, Handlers{
+		"status": func() (interface{}, error) {
+			return map[string]int{"sessions": 2}, nil
+		},
+		"broken": func() (interface{}, error) {
+			return nil, errors.New("boom")
+		},
+	})
+	result := map[string]int{}
+	if err := Query(path, "status", &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["sessions"] != 2 {
+		t.Fatalf("unexpected result %v", result)
+	}
+	if err := Query(path, "broken", &result); err == nil || err.Error() != "boom" {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if err := Query(path, "nope", &result); err == nil || !strings.Contains(err.Error(), "broken, status") {
+		t.Fatalf("expected unknown command error, got %v", err)
+	}
+	//a socket in use is not replaced
+	if _, err := Listen(path); err == nil {
+		t.Fatal("expected socket in use error")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected private socket, got %s", info.Mode())
+	}
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chisel.sock")
+	//a socket without a process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %s", err)
+	}
+	l.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket to be removed on close, got %v", err)
+	}
+	//other files are never removed
+	file := filepath.Join(dir, "chisel.json")
+	if err := os.WriteFile(file, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("expected not a socket error, got %v", err)
+	}
+	if b, err := os.ReadFile(file); err != nil || string(b) != "{}" {
+		t.Fatalf("expected file to be kept, got %q %v", b, err)
+	}
+}
+
+func TestServeConnCleanup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chisel.sock")
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Serve(ctx, l, Handlers{
+		"status": func() (interface{}, error) {
+			return "ok", nil
+		},
+	})
+	result := ""
+	if err := Query(path, "status", &result); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		if err := Query(path, "status", &result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//connections leave no goroutines behind
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,258 @@
+// Package egress implements the outbound destination policy of
+// the chisel server.
+//
+// A policy is made of allow and deny rules, each of which is a
+// target and an optional port or port range:
+//
+//	10.0.0.0/8            a network in CIDR notation
+//	192.168.1.10          a single IP address
+//	*.internal:443        a host name glob
+//	*:22                  any destination on port 22
+//	[fd00::/8]:8000-8999  an IPv6 network on a range of ports
+//
+// Host names are resolved before network rules are checked, and
+// connections are made to the checked IP, so that a name cannot
+// resolve to another address between the check and the dial.
+//
+// The Defaults networks are denied unless allowed, or exempted by
+// an unblock rule, which unlike an allow rule leaves the policy
+// allowing other destinations.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+// ErrDenied is returned when dialing a denied destination
+var ErrDenied = errors.New("egress denied")
+
+// Defaults are the networks denied unless allowed or
+// unblocked by a network rule: loopback, unspecified,
+// link-local (including 169.254.169.254) and cloud
+// metadata addresses
+var Defaults = []string{
+	"127.0.0.0/8",
+	"::1/128",
+	"0.0.0.0/8",
+	"::/128",
+	"169.254.0.0/16",
+	"fe80::/10",
+	"fd00:ec2::254/128",
+	"100.100.100.200/32",
+}
+
+var defaults = mustParseNets(Defaults)
+
+// Config of a Policy
+type Config struct {
+	Allow []string
+	Deny  []string
+	// Unblock exempts networks from the Defaults,
+	// without otherwise restricting destinations
+	Unblock []string
+}
+
+// Policy decides which destinations may be dialed
+type Policy struct {
+	allow   []rule
+	deny    []rule
+	unblock []rule
+}
+
+// New creates a Policy from the given rules
+func New(c Config) (*Policy, error) {
+	p := &Policy{}
+	for _, s := range c.Allow {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		p.allow = append(p.allow, r)
+	}
+	for _, s := range c.Deny {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		p.deny = append(p.deny, r)
+	}
+	for _, s := range c.Unblock {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		if r.cidr == nil {
+			return nil, fmt.Errorf("Invalid egress unblock rule %q, expected a network", s)
+		}
+		p.unblock = append(p.unblock, r)
+	}
+	return p, nil
+}
+
+// Allowed returns whether the port of ip, resolved from host, may
+// be dialed. Deny rules are checked first, then network allow rules,
+// the default networks unless unblocked, and finally host allow rules.
+// When there are no allow rules, other destinations are allowed.
+func (p *Policy) Allowed(host string, ip net.IP, port int) bool {
+	if p == nil {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, r := range p.deny {
+		if r.match(host, ip, port) {
+			return false
+		}
+	}
+	for _, r := range p.allow {
+		if r.cidr != nil && r.match(host, ip, port) {
+			return true
+		}
+	}
+	if !p.unblocked(ip, port) {
+		for _, n := range defaults {
+			if n.Contains(ip) {
+				return false
+			}
+		}
+	}
+	for _, r := range p.allow {
+		if r.cidr == nil && r.match(host, ip, port) {
+			return true
+		}
+	}
+	return len(p.allow) == 0
+}
+
+func (p *Policy) unblocked(ip net.IP, port int) bool {
+	for _, r := range p.unblock {
+		if r.match("", ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial connects to the address if allowed by the policy, a nil
+// Policy allows all addresses. Names are resolved and dialed by
+// their first allowed IP.
+func (p *Policy) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{}
+	if p == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	host, service, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	err = fmt.Errorf("%w: %s", ErrDenied, addr)
+	for _, ip := range ips {
+		if !p.Allowed(host, ip, port) {
+			continue
+		}
+		var c net.Conn
+		c, err = d.DialContext(ctx, network, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// rule matches a network or host glob, on a range of ports
+type rule struct {
+	cidr   *net.IPNet
+	glob   string
+	lo, hi int
+}
+
+func (r rule) match(host string, ip net.IP, port int) bool {
+	if r.lo > 0 && (port < r.lo || port > r.hi) {
+		return false
+	}
+	if r.cidr != nil {
+		return ip != nil && r.cidr.Contains(ip)
+	}
+	ok, _ := path.Match(r.glob, host)
+	return ok
+}
+
+func parseRule(s string) (rule, error) {
+	r := rule{}
+	target, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		//[ipv6-target]:ports
+		i := strings.Index(s, "]")
+		if i < 0 {
+			return r, fmt.Errorf("Invalid egress rule %q", s)
+		}
+		target = s[1:i]
+		if rest := s[i+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return r, fmt.Errorf("Invalid egress rule %q", s)
+			}
+			ports = rest[1:]
+		}
+	} else if strings.Count(s, ":") == 1 {
+		target, ports, _ = strings.Cut(s, ":")
+	}
+	if target == "" {
+		return r, fmt.Errorf("Invalid egress rule %q", s)
+	}
+	if ports != "" {
+		lo, hi, ok := strings.Cut(ports, "-")
+		if !ok {
+			hi = lo
+		}
+		var err1, err2 error
+		r.lo, err1 = strconv.Atoi(lo)
+		r.hi, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || r.lo < 1 || r.hi > 65535 || r.lo > r.hi {
+			return r, fmt.Errorf("Invalid egress rule %q, bad port range", s)
+		}
+	}
+	if n, err := settings.ParseSource(target); err == nil {
+		r.cidr = n
+		return r, nil
+	}
+	r.glob = strings.TrimSuffix(strings.ToLower(target), ".")
+	if _, err := path.Match(r.glob, ""); err != nil || strings.ContainsAny(r.glob, "/ ") {
+		return r, fmt.Errorf("Invalid egress rule %q, bad host glob", s)
+	}
+	return r, nil
+}
+
+func mustParseNets(nets []string) []*net.IPNet {
+	out := make([]*net.IPNet, len(nets))
+	for i, s := range nets {
+		n, err := settings.ParseSource(s)
+		if err != nil {
+			panic(err)
+		}
+		out[i] = n
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		c    Config
+		host string
+		port int
+		ok   bool
+	}{
+		{"public", Config{}, "93.184.216.34", 80, true},
+		{"loopback", Config{}, "127.0.0.1", 80, false},
+		{"loopback v6", Config{}, "::1", 80, false},
+		{"mapped loopback", Config{}, "::ffff:127.0.0.1", 80, false},
+		{"unspecified", Config{}, "0.0.0.0", 80, false},
+		{"metadata", Config{}, "169.254.169.254", 80, false},
+		{"metadata v6", Config{}, "fd00:ec2::254", 80, false},
+		{"link-local v6", Config{}, "fe80::1", 80, false},
+		{"allowed loopback", Config{Allow: []string{"127.0.0.1"}}, "127.0.0.1", 80, true},
+		{"allowed loopback port", Config{Allow: []string{"127.0.0.0/8:80"}}, "127.0.0.1", 81, false},
+		{"glob cannot allow defaults", Config{Allow: []string{"*"}}, "169.254.169.254", 80, false},
+		{"unblocked loopback", Config{Unblock: []string{"127.0.0.1"}}, "127.0.0.1", 80, true},
+		{"unblock is not an allow list", Config{Unblock: []string{"127.0.0.1"}}, "93.184.216.34", 80, true},
+		{"unblocked loopback port", Config{Unblock: []string{"127.0.0.0/8:80"}}, "127.0.0.1", 81, false},
+		{"unblock only exempts", Config{Unblock: []string{"127.0.0.1"}}, "169.254.169.254", 80, false},
+		{"deny before unblock", Config{Unblock: []string{"127.0.0.0/8"}, Deny: []string{"127.0.0.1"}}, "127.0.0.1", 80, false},
+		{"unblock within allow list", Config{Allow: []string{"10.0.0.0/8"}, Unblock: []string{"127.0.0.1"}}, "127.0.0.1", 80, false},
+		{"allow list", Config{Allow: []string{"10.0.0.0/8"}}, "10.1.2.3", 80, true},
+		{"outside allow list", Config{Allow: []string{"10.0.0.0/8"}}, "93.184.216.34", 80, false},
+		{"deny before allow", Config{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}}, "10.0.0.1", 80, false},
+		{"denied port", Config{Deny: []string{"*:22"}}, "93.184.216.34", 22, false},
+		{"denied port range", Config{Deny: []string{"*:1-1024"}}, "93.184.216.34", 1025, true},
+		{"v6 network ports", Config{Deny: []string{"[2001:db8::/32]:8000-8999"}}, "2001:db8::1", 8080, false},
+		{"v6 network", Config{Deny: []string{"2001:db8::/32"}}, "2001:db8::1", 80, false},
+	} {
+		p, err := New(tc.c)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if ok := p.Allowed(tc.host, net.ParseIP(tc.host), tc.port); ok != tc.ok {
+			t.Fatalf("%s: expected %v", tc.name, tc.ok)
+		}
+	}
+}
+
+func TestAllowedHost(t *testing.T) {
+	p, err := New(Config{
+		Allow: []string{"*.example.com:443"},
+		Deny:  []string{"admin.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := net.ParseIP("93.184.216.34")
+	for _, tc := range []struct {
+		host string
+		ip   net.IP
+		port int
+		ok   bool
+	}{
+		{"www.example.com", public, 443, true},
+		{"WWW.Example.com.", public, 443, true},
+		{"www.example.com", public, 80, false},
+		{"admin.example.com", public, 443, false},
+		{"example.org", public, 443, false},
+		//names resolving to denied networks
+		{"www.example.com", net.ParseIP("127.0.0.1"), 443, false},
+	} {
+		if ok := p.Allowed(tc.host, tc.ip, tc.port); ok != tc.ok {
+			t.Fatalf("%s (%s) port %d: expected %v", tc.host, tc.ip, tc.port, tc.ok)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, s := range []string{"", ":80", "*:0", "*:80-79", "*:65536", "10.0.0.0/8:x", "[::1", "[::1]80", "a b", "[a"} {
+		if _, err := New(Config{Deny: []string{s}}); err == nil {
+			t.Fatalf("expected %q to be invalid", s)
+		}
+	}
+	//unblock rules are networks
+	if _, err := New(Config{Unblock: []string{"localhost"}}); err == nil {
+		t.Fatal("expected host globs to be invalid unblock rules")
+	}
+}
+
+func TestDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	ctx := context.Background()
+	//nil policies allow all
+	var none *Policy
+	c, err := none.Dial(ctx, "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	p, _ := New(Config{})
+	if _, err := p.Dial(ctx, "tcp", l.Addr().String()); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected loopback to be denied, got %v", err)
+	}
+	//names are checked by their resolved address
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	if _, err := p.Dial(ctx, "tcp", "localhost:"+port); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected localhost to be denied, got %v", err)
+	}
+	p, _ = New(Config{Allow: []string{"127.0.0.1"}})
+	c, err = p.Dial(ctx, "tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
@@ -0,0 +1,229 @@
+// Package metrics is a minimal collection of counters and gauges,
+// exposed in the Prometheus text exposition format (version 0.0.4).
+//
+// All types are safe for concurrent use, and nil values are valid
+// no-ops, so metric collection can be disabled by passing nil.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds a set of metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*Vec
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "counter", labels)
+}
+
+// Gauge registers a gauge with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "gauge", labels)
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *Vec {
+	if r == nil {
+		return nil
+	}
+	v := &Vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: map[string]*Value{},
+	}
+	//unlabelled metrics are always exposed
+	if len(labels) == 0 {
+		v.With()
+	}
+	r.mu.Lock()
+	r.metrics = append(r.metrics, v)
+	r.mu.Unlock()
+	return v
+}
+
+// WriteTo writes all metrics in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*Vec{}, r.metrics...)
+	r.mu.Unlock()
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.writeTo(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Vec is a metric partitioned by label values
+type Vec struct {
+	name, help, kind string
+	labels           []string
+	mu               sync.RWMutex
+	values           map[string]*Value
+}
+
+// With returns the Value for the given label values,
+// which must be in the order of the registered labels
+func (v *Vec) With(values ...string) *Value {
+	if v == nil {
+		return nil
+	}
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels", v.name, len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	val, ok := v.values[key]
+	v.mu.RUnlock()
+	if ok {
+		return val
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if val, ok := v.values[key]; ok {
+		return val
+	}
+	val = &Value{labels: values}
+	v.values[key] = val
+	return val
+}
+
+// Delete removes the series whose given label has the
+// value, so that they are no longer exposed
+func (v *Vec) Delete(label, value string) {
+	if v == nil {
+		return
+	}
+	i := 0
+	for i < len(v.labels) && v.labels[i] != label {
+		i++
+	}
+	if i == len(v.labels) {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for k, val := range v.values {
+		if val.labels[i] == value {
+			delete(v.values, k)
+		}
+	}
+}
+
+func (v *Vec) writeTo(w io.Writer) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]*Value, len(keys))
+	for i, k := range keys {
+		values[i] = v.values[k]
+	}
+	v.mu.RUnlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escape(v.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+	for _, val := range values {
+		io.WriteString(w, v.name)
+		if len(v.labels) > 0 {
+			pairs := make([]string, len(v.labels))
+			for i, l := range v.labels {
+				pairs[i] = l + `="` + escape(val.labels[i], true) + `"`
+			}
+			io.WriteString(w, "{"+strings.Join(pairs, ",")+"}")
+		}
+		fmt.Fprintf(w, " %d\n", val.Get())
+	}
+}
+
+// Value is a single metric series
+type Value struct {
+	labels []string
+	n      atomic.Int64
+}
+
+// Add adds n to the value
+func (v *Value) Add(n int64) {
+	if v != nil {
+		v.n.Add(n)
+	}
+}
+
+// Inc increments the value
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+// Dec decrements the value (gauges only)
+func (v *Value) Dec() {
+	v.Add(-1)
+}
+
+// Set the value (gauges only)
+func (v *Value) Set(n int64) {
+	if v != nil {
+		v.n.Store(n)
+	}
+}
+
+// Get the current value
+func (v *Value) Get() int64 {
+	if v == nil {
+		return 0
+	}
+	return v.n.Load()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escape(s string, label bool) string {
+	if label {
+		return labelEscaper.Replace(s)
+	}
+	return helpEscaper.Replace(s)
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
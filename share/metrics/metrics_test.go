@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	sessions := r.Gauge("chisel_sessions", "Connected sessions.")
+	bytes := r.Counter("chisel_sent_bytes_total", "Bytes sent.", "user", "remote")
+	sessions.With().Inc()
+	sessions.With().Inc()
+	sessions.With().Dec()
+	bytes.With("foo", "3000=>80").Add(42)
+	bytes.With("bar", `a"b`).Add(1)
+	sb := strings.Builder{}
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP chisel_sessions Connected sessions.
+# TYPE chisel_sessions gauge
+chisel_sessions 1
+# HELP chisel_sent_bytes_total Bytes sent.
+# TYPE chisel_sent_bytes_total counter
+chisel_sent_bytes_total{user="bar",remote="a\"b"} 1
+chisel_sent_bytes_total{user="foo",remote="3000=>80"} 42
+`
+	if got := sb.String(); got != expected {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var r *Registry
+	c := r.Counter("chisel_noop_total", "Nothing.", "remote")
+	c.With("x").Inc()
+	if n := c.With("x").Get(); n != 0 {
+		t.Fatalf("expected nil metric to be a no-op, got %d", n)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r := NewRegistry()
+	bytes := r.Counter("chisel_sent_bytes_total", "Bytes sent.", "user", "remote")
+	bytes.With("foo", "3000=>80").Add(42)
+	bytes.With("bar", "3000=>80").Add(1)
+	bytes.With("foo", "direct").Add(7)
+	bytes.Delete("remote", "3000=>80")
+	bytes.Delete("missing", "direct")
+	sb := strings.Builder{}
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.String(); strings.Contains(got, "3000=>80") || !strings.Contains(got, `remote="direct"} 7`) {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
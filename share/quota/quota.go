@@ -0,0 +1,160 @@
+// Package quota accounts the bytes transferred by each user
+// against their daily and monthly quotas. The usage may be
+// persisted to a file, so that it survives restarts. Days and
+// months are in UTC.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jpillora/sizestr"
+)
+
+// ErrExceeded is wrapped by the errors of exceeded quotas
+var ErrExceeded = errors.New("quota exceeded")
+
+// Limits of a user in bytes, 0 is unlimited
+type Limits struct {
+	Daily   int64
+	Monthly int64
+}
+
+// Usage of a user in bytes, sent and received,
+// during the current day and month
+type Usage struct {
+	Day     string `json:"day"`
+	Daily   int64  `json:"daily"`
+	Month   string `json:"month"`
+	Monthly int64  `json:"monthly"`
+}
+
+// roll resets the usage of past days and months
+func (u *Usage) roll(t time.Time) {
+	t = t.UTC()
+	if day := t.Format("2006-01-02"); u.Day != day {
+		u.Day, u.Daily = day, 0
+	}
+	if month := t.Format("2006-01"); u.Month != month {
+		u.Month, u.Monthly = month, 0
+	}
+}
+
+// Store holds the usage of each user
+type Store struct {
+	mut     sync.Mutex
+	saveMut sync.Mutex
+	path    string
+	users   map[string]*Usage
+	dirty   bool
+	now     func() time.Time
+}
+
+// Open loads the usage saved in the file at path, which is
+// created on the first save. An empty path is not persisted.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, users: map[string]*Usage{}, now: time.Now}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.users); err != nil {
+		return nil, fmt.Errorf("Invalid quota file %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// usage returns the current usage of the user, the
+// caller must hold the lock
+func (s *Store) usage(user string) *Usage {
+	u, ok := s.users[user]
+	if !ok {
+		u = &Usage{}
+		s.users[user] = u
+	}
+	u.roll(s.now())
+	return u
+}
+
+// Add counts n bytes transferred by the user
+func (s *Store) Add(user string, n int64) {
+	if n <= 0 {
+		return
+	}
+	s.mut.Lock()
+	u := s.usage(user)
+	u.Daily += n
+	u.Monthly += n
+	s.dirty = true
+	s.mut.Unlock()
+}
+
+// Usage returns the current usage of the user
+func (s *Store) Usage(user string) Usage {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return *s.usage(user)
+}
+
+// Check returns an error wrapping ErrExceeded once
+// the usage of the user reaches one of the limits
+func (s *Store) Check(user string, l Limits) error {
+	if l.Daily <= 0 && l.Monthly <= 0 {
+		return nil
+	}
+	u := s.Usage(user)
+	if l.Daily > 0 && u.Daily >= l.Daily {
+		return fmt.Errorf("daily %w (%s)", ErrExceeded, sizestr.ToString(l.Daily))
+	}
+	if l.Monthly > 0 && u.Monthly >= l.Monthly {
+		return fmt.Errorf("monthly %w (%s)", ErrExceeded, sizestr.ToString(l.Monthly))
+	}
+	return nil
+}
+
+// Save writes the usage of the current month to the file,
+// when it changed since the last save
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.saveMut.Lock()
+	defer s.saveMut.Unlock()
+	s.mut.Lock()
+	if !s.dirty {
+		s.mut.Unlock()
+		return nil
+	}
+	current := map[string]*Usage{}
+	for name := range s.users {
+		if u := s.usage(name); u.Monthly > 0 {
+			current[name] = u
+		}
+	}
+	b, _ := json.MarshalIndent(current, "", "  ")
+	s.dirty = false
+	s.mut.Unlock()
+	//replace the file, so that it is never partially written
+	tmp := s.path + ".tmp"
+	err := os.WriteFile(tmp, b, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		s.mut.Lock()
+		s.dirty = true
+		s.mut.Unlock()
+		return fmt.Errorf("Failed to save quota file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	now := time.Date(2030, 1, 30, 23, 0, 0, 0, time.UTC)
+	s, _ := Open("")
+	s.now = func() time.Time { return now }
+	l := Limits{Daily: 100, Monthly: 150}
+	s.Add("foo", 60)
+	if err := s.Check("foo", l); err != nil {
+		t.Fatal(err)
+	}
+	s.Add("foo", 40)
+	if err := s.Check("foo", l); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("expected daily quota to be exceeded, got %v", err)
+	}
+	if err := s.Check("bar", l); err != nil {
+		t.Fatalf("expected other users to be unaffected, got %v", err)
+	}
+	//the next day, within the month
+	now = now.Add(2 * time.Hour)
+	if err := s.Check("foo", l); err != nil {
+		t.Fatal(err)
+	}
+	s.Add("foo", 50)
+	if err := s.Check("foo", l); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("expected monthly quota to be exceeded, got %v", err)
+	}
+	if u := s.Usage("foo"); u.Day != "2030-01-31" || u.Daily != 50 || u.Monthly != 150 {
+		t.Fatalf("unexpected usage %+v", u)
+	}
+	//the next month
+	now = now.Add(24 * time.Hour)
+	if err := s.Check("foo", l); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotaSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("foo", 42)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := s.Usage("foo"); u.Daily != 42 || u.Monthly != 42 {
+		t.Fatalf("expected usage to be restored, got %+v", u)
+	}
+}
@@ -8,8 +8,19 @@ import (
 type Config struct {
 	Version string
 	Remotes
+	//Connections of the client, when it makes extra
+	//connections, for which the server replies with
+	//the token of the session they join
+	Connections int `json:",omitempty"`
+	//Join is the token of the session which an
+	//extra connection joins
+	Join string `json:",omitempty"`
 }
 
+//MaxConnections bounds the connections of a client,
+//its primary connection and the extra ones
+const MaxConnections = 16
+
 func DecodeConfig(b []byte) (*Config, error) {
 	c := &Config{}
 	err := json.Unmarshal(b, c)
@@ -24,3 +35,26 @@ func EncodeConfig(c Config) []byte {
 	b, _ := json.Marshal(c)
 	return b
 }
+
+//RemotesUpdate adds and removes remotes of a connected client
+type RemotesUpdate struct {
+	Add    Remotes
+	Remove Remotes
+	//Limit changes the rate of bound remotes
+	Limit Remotes `json:",omitempty"`
+}
+
+func DecodeRemotesUpdate(b []byte) (*RemotesUpdate, error) {
+	u := &RemotesUpdate{}
+	err := json.Unmarshal(b, u)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JSON remotes update")
+	}
+	return u, nil
+}
+
+func EncodeRemotesUpdate(u RemotesUpdate) []byte {
+	//RemotesUpdate doesn't have types that can fail to marshal
+	b, _ := json.Marshal(u)
+	return b
+}
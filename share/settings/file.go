@@ -0,0 +1,166 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConfigFile is a JSON configuration file, YAML is not
+// supported yet. References to CHISEL_* environment
+// variables, in the form $CHISEL_NAME or ${CHISEL_NAME},
+// are expanded before decoding, and must be set.
+type ConfigFile struct {
+	Path string
+	raw  []byte
+	data []byte
+}
+
+var envRef = regexp.MustCompile(`\$\{(CHISEL_\w+)\}|\$(CHISEL_\w+)`)
+
+// ReadConfigFile reads and expands the given configuration file
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("%s: YAML is not supported yet, please use JSON", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &ConfigFile{Path: path, raw: b, data: b}
+	data := []byte{}
+	last := 0
+	for _, m := range envRef.FindAllSubmatchIndex(b, -1) {
+		//either ${CHISEL_NAME} or $CHISEL_NAME matched
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		name := string(b[start:end])
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, f.errorAt(m[0], fmt.Errorf("environment variable %s is not set", name))
+		}
+		//escaped, so that values can be placed inside strings
+		//without adding quotes or newlines to the document
+		e, _ := json.Marshal(v)
+		data = append(data, b[last:m[0]]...)
+		data = append(data, e[1:len(e)-1]...)
+		last = m[1]
+	}
+	f.data = append(data, b[last:]...)
+	return f, nil
+}
+
+// Decode the file into v, unknown fields are rejected
+func (f *ConfigFile) Decode(v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(f.data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&json.RawMessage{}) != io.EOF {
+		return f.errorAt(int(dec.InputOffset()), errors.New("unexpected data after the top-level object"))
+	}
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	var val *ValueError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return f.errorAt(len(bytes.TrimRight(f.data, " \t\r\n")), errors.New("unexpected end of file"))
+	case errors.As(err, &syntax):
+		return f.errorAt(int(syntax.Offset), err)
+	case errors.As(err, &typ):
+		return f.errorAt(int(typ.Offset), fmt.Errorf("invalid %s for '%s'", typ.Value, typ.Field))
+	case errors.As(err, &val):
+		return f.errorAt(bytes.Index(f.data, val.Raw), val.Err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return f.errorAt(bytes.Index(f.data, []byte(field)), fmt.Errorf("unknown field %s", field))
+	}
+	return f.errorAt(-1, err)
+}
+
+// Has returns whether the file has the given top-level field
+func (f *ConfigFile) Has(field string) bool {
+	m := map[string]json.RawMessage{}
+	json.Unmarshal(f.data, &m)
+	_, ok := m[field]
+	return ok
+}
+
+// Errorf returns an error about the given string value,
+// with the context of the line in which it appears
+func (f *ConfigFile) Errorf(value string, format string, args ...interface{}) error {
+	lit, _ := json.Marshal(value)
+	return f.errorAt(bytes.Index(f.data, lit), fmt.Errorf(format, args...))
+}
+
+func (f *ConfigFile) errorAt(offset int, err error) error {
+	e := &FileError{Path: f.Path, Err: err}
+	if offset >= 0 && offset <= len(f.data) {
+		//expanded values contain no newlines,
+		//so lines match those of the original file
+		e.Line = bytes.Count(f.data[:offset], []byte("\n")) + 1
+		lines := strings.Split(string(f.raw), "\n")
+		if e.Line <= len(lines) {
+			e.Text = strings.TrimRight(lines[e.Line-1], "\r")
+		}
+	}
+	return e
+}
+
+// FileError is an error in a configuration file
+type FileError struct {
+	Path string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s\n  %4d | %s", e.Path, e.Line, e.Err, e.Line, e.Text)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ValueError is returned by custom JSON decoders of configuration
+// values, Raw is used to locate the value in the file
+type ValueError struct {
+	Raw []byte
+	Err error
+}
+
+func (e *ValueError) Error() string {
+	return e.Err.Error()
+}
+
+// Duration is a time.Duration decoded from a
+// JSON string in the time.ParseDuration format
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return &ValueError{b, errors.New(`invalid duration, expected a string like "25s"`)}
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return &ValueError{b, fmt.Errorf("invalid duration %q", s)}
+	}
+	*d = Duration(v)
+	return nil
+}
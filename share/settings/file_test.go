@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) *ConfigFile {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestConfigFileDecode(t *testing.T) {
+	t.Setenv("CHISEL_TEST_AUTH", `foo:"bar"`)
+	f := writeConfigFile(t, "c.json", `{
+		"auth": "${CHISEL_TEST_AUTH}",
+		"keepalive": "5s",
+		"other": "$HOME"
+	}`)
+	v := struct {
+		Auth      string   `json:"auth"`
+		KeepAlive Duration `json:"keepalive"`
+		Other     string   `json:"other"`
+	}{}
+	if err := f.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Auth != `foo:"bar"` {
+		t.Fatalf("expected expanded auth, got %s", v.Auth)
+	}
+	if time.Duration(v.KeepAlive) != 5*time.Second {
+		t.Fatalf("expected 5s keepalive, got %s", time.Duration(v.KeepAlive))
+	}
+	if v.Other != "$HOME" {
+		t.Fatalf("expected only CHISEL_ variables to be expanded, got %s", v.Other)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	v := struct {
+		Port      int      `json:"port"`
+		KeepAlive Duration `json:"keepalive"`
+	}{}
+	for i, test := range []struct {
+		content string
+		err     string
+	}{
+		{"{\n\"port\": 1,\n\"host\": \"x\"\n}", `c.json:3: unknown field "host"`},
+		{"{\n\"port\": \"1\"\n}", `c.json:2: invalid string for 'port'`},
+		{"{\n\"port\": 1,\n\"keepalive\": \"5y\"\n}", `c.json:3: invalid duration "5y"`},
+		{"{\n\"port\": 1\n\n", `c.json:2: unexpected end of file`},
+	} {
+		err := writeConfigFile(t, "c.json", test.content).Decode(&v)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("#%d: expected error %q, got %v", i+1, test.err, err)
+		}
+	}
+	//unset variables are not expanded into empty strings
+	path := filepath.Join(t.TempDir(), "c.json")
+	os.WriteFile(path, []byte("{\n\"auth\": \"foo:$CHISEL_TEST_UNSET\"\n}"), 0600)
+	if _, err := ReadConfigFile(path); err == nil || !strings.Contains(err.Error(), "c.json:2: environment variable CHISEL_TEST_UNSET is not set") {
+		t.Fatalf("expected unset variable error, got %v", err)
+	}
+	if _, err := ReadConfigFile("c.yaml"); err == nil {
+		t.Fatal("expected yaml to be rejected")
+	}
+}
@@ -0,0 +1,183 @@
+package settings
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jpillora/chisel/share/cio"
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseAuthorizedKeys parses users in the authorized_keys format
+// of OpenSSH, one public key per line:
+//
+//	[options] <key-type> <base64-key> [comment]
+//
+// where options is a comma separated list of:
+//
+//	user="<name>"       the user name, defaults to the comment,
+//	                    or the fingerprint of the key
+//	addr="<addr-regex>" an address the user may access, as in the
+//	                    users.json format, keys without any have
+//	                    access to all addresses
+//	cert-authority      the key is a certificate authority, whose
+//	                    certificates authenticate their principals
+func ParseAuthorizedKeys(b []byte) (*AuthorizedKeys, error) {
+	keys := &AuthorizedKeys{
+		Keys:        map[string]*User{},
+		Authorities: map[string]*User{},
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, user, authority, err := parseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		index := keys.Keys
+		if authority {
+			index = keys.Authorities
+		}
+		if _, exists := index[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key", n)
+		}
+		index[key] = user
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AuthorizedKeys are the users of an authorized_keys file
+type AuthorizedKeys struct {
+	//Keys are the users by the wire format of their key
+	Keys map[string]*User
+	//Authorities are the certificate authorities by the wire
+	//format of their key, along with the addresses which their
+	//principals may access when they are not a known user
+	Authorities map[string]*User
+}
+
+func parseAuthorizedKey(line string) (key string, user *User, authority bool, err error) {
+	pub, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", nil, false, fmt.Errorf("invalid key (%s)", strings.TrimPrefix(err.Error(), "ssh: "))
+	}
+	user = &User{Name: comment}
+	named := false
+	for _, o := range options {
+		if o == "cert-authority" {
+			authority = true
+			continue
+		}
+		name, value, ok := strings.Cut(o, "=")
+		if !ok || len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+			return "", nil, false, fmt.Errorf("invalid option %s, expected name=\"value\"", o)
+		}
+		value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+		switch name {
+		case "user":
+			user.Name = value
+			named = true
+		case "addr":
+			if value == "" || value == "*" {
+				user.Addrs = append(user.Addrs, UserAllowAll)
+				continue
+			}
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return "", nil, false, errors.New("invalid address regex")
+			}
+			user.Addrs = append(user.Addrs, re)
+		default:
+			return "", nil, false, fmt.Errorf("unknown option %s", name)
+		}
+	}
+	if authority && named {
+		//named by the principals of their certificates
+		return "", nil, false, errors.New("user option not supported by cert-authority keys")
+	}
+	if user.Name == "" {
+		user.Name = ssh.FingerprintSHA256(pub)
+	}
+	if len(user.Addrs) == 0 {
+		user.Addrs = []*regexp.Regexp{UserAllowAll}
+	}
+	return string(pub.Marshal()), user, authority, nil
+}
+
+// KeyIndex is a reloadable source of users by their public key
+type KeyIndex struct {
+	*cio.Logger
+	sync.RWMutex
+	inner      *AuthorizedKeys
+	configFile string
+}
+
+// NewKeyIndex creates a source for users with keys
+func NewKeyIndex(logger *cio.Logger) *KeyIndex {
+	return &KeyIndex{
+		Logger: logger.Fork("keys"),
+		inner:  &AuthorizedKeys{},
+	}
+}
+
+// Len returns the number of keys, including authorities
+func (k *KeyIndex) Len() int {
+	k.RLock()
+	defer k.RUnlock()
+	return len(k.inner.Keys) + len(k.inner.Authorities)
+}
+
+// Get returns the user of the given key
+func (k *KeyIndex) Get(key ssh.PublicKey) (*User, bool) {
+	k.RLock()
+	defer k.RUnlock()
+	user, ok := k.inner.Keys[string(key.Marshal())]
+	return user, ok
+}
+
+// Authority returns the given certificate authority
+func (k *KeyIndex) Authority(key ssh.PublicKey) (*User, bool) {
+	k.RLock()
+	defer k.RUnlock()
+	user, ok := k.inner.Authorities[string(key.Marshal())]
+	return user, ok
+}
+
+// LoadKeys loads the keys from an authorized_keys
+// file, and reloads them whenever the file changes
+func (k *KeyIndex) LoadKeys(configFile string) error {
+	k.configFile = configFile
+	k.Infof("Loading authorized keys file %s", configFile)
+	if err := k.loadKeys(); err != nil {
+		return err
+	}
+	return watchFile(k.Logger, configFile, "authorized keys", k.loadKeys)
+}
+
+func (k *KeyIndex) loadKeys() error {
+	b, err := os.ReadFile(k.configFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read authorized keys file: %s, error: %s", k.configFile, err)
+	}
+	keys, err := ParseAuthorizedKeys(b)
+	if err != nil {
+		return fmt.Errorf("%s: %s", k.configFile, err)
+	}
+	//swap
+	k.Lock()
+	k.inner = keys
+	k.Unlock()
+	return nil
+}
@@ -0,0 +1,78 @@
+package settings
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newAuthorizedKey(t *testing.T) (ssh.PublicKey, string) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	k1, line1 := newAuthorizedKey(t)
+	k2, line2 := newAuthorizedKey(t)
+	k3, line3 := newAuthorizedKey(t)
+	ca, caLine := newAuthorizedKey(t)
+	keys, err := ParseAuthorizedKeys([]byte(`# machines
+user="laptop",addr="^0.0.0.0:3000$",addr="^R:0.0.0.0:7000$" ` + line1 + ` me@laptop
+
+` + line2 + ` build-agent
+` + line3 + `
+cert-authority,addr="^example\.com:80$" ` + caLine + ` ca
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u1 := keys.Keys[string(k1.Marshal())]
+	if u1 == nil || u1.Name != "laptop" || len(u1.Addrs) != 2 {
+		t.Fatalf("unexpected user %+v", u1)
+	}
+	if !u1.HasAccess("R:0.0.0.0:7000") || u1.HasAccess("0.0.0.0:3001") {
+		t.Fatal("expected access to the listed addresses only")
+	}
+	u2 := keys.Keys[string(k2.Marshal())]
+	if u2 == nil || u2.Name != "build-agent" || !u2.HasAccess("example.com:80") {
+		t.Fatalf("expected comment name and full access, got %+v", u2)
+	}
+	u3 := keys.Keys[string(k3.Marshal())]
+	if u3 == nil || u3.Name != ssh.FingerprintSHA256(k3) {
+		t.Fatalf("expected fingerprint name, got %+v", u3)
+	}
+	if _, ok := keys.Keys[string(ca.Marshal())]; ok || len(keys.Keys) != 3 {
+		t.Fatal("expected authority to not be a user key")
+	}
+	if a := keys.Authorities[string(ca.Marshal())]; a == nil || !a.HasAccess("example.com:80") || a.HasAccess("example.com:81") {
+		t.Fatalf("unexpected authority %+v", a)
+	}
+}
+
+func TestParseAuthorizedKeysErrors(t *testing.T) {
+	_, line := newAuthorizedKey(t)
+	for input, expect := range map[string]string{
+		"ssh-ed25519 AAAA":                "line 1: invalid key",
+		"\n" + `user=foo ` + line:         "line 2: invalid option user=foo",
+		`no-pty ` + line:                  "line 1: invalid option no-pty",
+		`permitopen="x:1" ` + line:        "line 1: unknown option permitopen",
+		`addr="(" ` + line:                "line 1: invalid address regex",
+		line + "\n" + `user="x" ` + line:  "line 2: duplicate key",
+		`cert-authority,user="x" ` + line: "line 1: user option not supported",
+	} {
+		_, err := ParseAuthorizedKeys([]byte(input))
+		if err == nil || !strings.HasPrefix(err.Error(), expect) {
+			t.Fatalf("%q: expected error %q, got %v", input, expect, err)
+		}
+	}
+}
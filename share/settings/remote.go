@@ -2,11 +2,14 @@ package settings
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/jpillora/sizestr"
 )
 
 // short-hand conversions (see remote_test)
@@ -31,11 +34,18 @@ import (
 //   1.1.1.1:53/udp
 //     local  127.0.0.1:53/udp
 //     remote 1.1.1.1:53/udp
+//   3000:google.com:80@1M
+//     local  127.0.0.1:3000
+//     remote google.com:80
+//     limited to 1MB/s
 
 type Remote struct {
 	LocalHost, LocalPort, LocalProto    string
 	RemoteHost, RemotePort, RemoteProto string
 	Socks, Reverse, Stdio               bool
+	//Rate limits the bytes per second of the remote,
+	//it is not part of the encoded remote
+	Rate int64 `json:",omitempty"`
 }
 
 const revPrefix = "R:"
@@ -46,11 +56,19 @@ func DecodeRemote(s string) (*Remote, error) {
 		s = strings.TrimPrefix(s, revPrefix)
 		reverse = true
 	}
+	rate := int64(0)
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		n, err := ParseSize(s[i+1:])
+		if err != nil {
+			return nil, errors.New("Invalid rate")
+		}
+		s, rate = s[:i], n
+	}
 	parts := regexp.MustCompile(`(\[[^\[\]]+\]|[^\[\]:]+):?`).FindAllStringSubmatch(s, -1)
 	if len(parts) <= 0 || len(parts) >= 5 {
 		return nil, errors.New("Invalid remote")
 	}
-	r := &Remote{Reverse: reverse}
+	r := &Remote{Reverse: reverse, Rate: rate}
 	//parse from back to front, to set 'remote' fields first,
 	//then to set 'local' fields second (allows the 'remote' side
 	//to provide the defaults)
@@ -132,6 +150,21 @@ func DecodeRemote(s string) (*Remote, error) {
 	return r, nil
 }
 
+var sizeRegexp = regexp.MustCompile(`(?i)^\d+(\.\d+)?[kmgt]?i?b?$`)
+
+//ParseSize parses a number of bytes, such as 500K,
+//1MB or 1Mi (the trailing B is optional), as used
+//by rates and quotas
+func ParseSize(s string) (int64, error) {
+	if !sizeRegexp.MatchString(s) {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+	if !strings.HasSuffix(strings.ToLower(s), "b") {
+		s += "B"
+	}
+	return sizestr.Parse(s)
+}
+
 func isPort(s string) bool {
 	n, err := strconv.Atoi(s)
 	if err != nil {
@@ -226,11 +259,6 @@ func (r Remote) UserAddr() string {
 
 //CanListen checks if the port can be listened on
 func (r Remote) CanListen() bool {
-	// For reverse tunnels, the listening happens on the client side,
-	// so we don't need to check if the server can listen on this port
-	if r.Reverse {
-		return true
-	}
 	//valid protocols
 	switch r.LocalProto {
 	case "tcp":
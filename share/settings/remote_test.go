@@ -111,6 +111,29 @@ func TestRemoteDecode(t *testing.T) {
 			},
 			"R:[::]:3000:[::1]:3000",
 		},
+		{
+			"3000:google.com:80@1M",
+			Remote{
+				LocalPort:  "3000",
+				RemoteHost: "google.com",
+				RemotePort: "80",
+				Rate:       1000000,
+			},
+			"0.0.0.0:3000:google.com:80",
+		},
+		{
+			"R:1.1.1.1:53/udp@64K",
+			Remote{
+				LocalPort:   "53",
+				LocalProto:  "udp",
+				RemoteHost:  "1.1.1.1",
+				RemotePort:  "53",
+				RemoteProto: "udp",
+				Reverse:     true,
+				Rate:        64000,
+			},
+			"R:0.0.0.0:53:1.1.1.1:53/udp",
+		},
 	} {
 		//expected defaults
 		expected := test.Output
@@ -136,3 +159,23 @@ func TestRemoteDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	for s, n := range map[string]int64{
+		"0":     0,
+		"100":   100,
+		"500K":  500000,
+		"1MB":   1000000,
+		"1Mi":   1048576,
+		"1.5mb": 1500000,
+	} {
+		if got, err := ParseSize(s); err != nil || got != n {
+			t.Fatalf("ParseSize(%q): expected %d, got %d %v", s, n, got, err)
+		}
+	}
+	for _, s := range []string{"", "fast", "-1M", "1M/s", "1 M"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Fatalf("ParseSize(%q): expected an error", s)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package settings
 
 import (
+	"crypto/subtle"
+	"fmt"
+	"net"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 var UserAllowAll = regexp.MustCompile("")
@@ -19,15 +26,137 @@ type User struct {
 	Name  string
 	Pass  string
 	Addrs []*regexp.Regexp
+	//Deny addresses take precedence over Addrs
+	Deny []*regexp.Regexp
+	//MaxSessions limits the concurrent sessions of the
+	//user, and MaxChannels the open channels of each
+	//session (0 is unlimited)
+	MaxSessions int
+	MaxChannels int
+	//Sources, when set, are the networks from which
+	//the user may connect
+	Sources []*net.IPNet
+	//Expires, when set, is when the user stops
+	//being able to connect
+	Expires time.Time
+	//DenySocks and DenyReverse prohibit the
+	//user from using SOCKS and reverse remotes
+	DenySocks   bool
+	DenyReverse bool
+	//Bandwidth limits the bytes per second of all
+	//sessions of the user combined (0 is unlimited)
+	Bandwidth int64
+	//QuotaDaily and QuotaMonthly limit the bytes the
+	//user may transfer each day and month (0 is unlimited)
+	QuotaDaily   int64
+	QuotaMonthly int64
+	//Scope, when set, further restricts the access of
+	//Addrs to addresses which also match one of its
+	Scope []*regexp.Regexp
+}
+
+//Within returns a copy of the user whose access is restricted
+//to the addresses which also match one of the given ones
+func (u *User) Within(addrs []*regexp.Regexp) *User {
+	c := *u
+	c.Scope = addrs
+	return &c
+}
+
+//CheckPassword compares the password with the user's password,
+//which is either plaintext or a bcrypt hash, in constant time
+func (u *User) CheckPassword(password string) bool {
+	if IsHashedPassword(u.Pass) {
+		return bcrypt.CompareHashAndPassword([]byte(u.Pass), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Pass), []byte(password)) == 1
+}
+
+//unknownHash is compared with the passwords of unknown users
+var unknownHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("unknown"), bcrypt.DefaultCost)
+	return h
+})
+
+//RejectPassword rejects the password of an unknown user, taking
+//as long as checking the bcrypt hash of a user, so that the
+//time to fail does not reveal which users exist
+func RejectPassword(password string) {
+	bcrypt.CompareHashAndPassword(unknownHash(), []byte(password))
 }
 
+//IsHashedPassword returns whether the password
+//is a bcrypt hash ($2a$, $2b$ or $2y$)
+func IsHashedPassword(pass string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(pass, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//HashPassword returns the bcrypt hash of the password,
+//using the default cost when cost is 0
+func HashPassword(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", fmt.Errorf("Invalid bcrypt cost %d (expected %d to %d)", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+//ValidatePassword checks that a hashed password is well formed
+func ValidatePassword(pass string) error {
+	if !IsHashedPassword(pass) {
+		return nil
+	}
+	if _, err := bcrypt.Cost([]byte(pass)); err != nil {
+		return fmt.Errorf("Invalid password hash (%s)", err)
+	}
+	return nil
+}
+
+//HasAccess returns whether the address matches one
+//of the user's addresses, and none of its deny rules
 func (u *User) HasAccess(addr string) bool {
-	m := false
-	for _, r := range u.Addrs {
+	for _, r := range u.Deny {
 		if r.MatchString(addr) {
-			m = true
-			break
+			return false
+		}
+	}
+	return matchAny(u.Addrs, addr) && (u.Scope == nil || matchAny(u.Scope, addr))
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, r := range res {
+		if r.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+//HasSource returns whether the user may connect from the IP
+func (u *User) HasSource(ip net.IP) bool {
+	if len(u.Sources) == 0 {
+		return true
+	}
+	for _, n := range u.Sources {
+		if ip != nil && n.Contains(ip) {
+			return true
 		}
 	}
-	return m
+	return false
+}
+
+//Expired returns whether the user has expired at time t
+func (u *User) Expired(t time.Time) bool {
+	return !u.Expires.IsZero() && !t.Before(u.Expires)
 }
@@ -0,0 +1,174 @@
+package settings
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		pass, password string
+		ok             bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "secre", false},
+		{"secret", "", false},
+		{hash, "secret", true},
+		{hash, "Secret", false},
+		{hash, hash, false},
+	} {
+		u := &User{Name: "foo", Pass: tc.pass}
+		if ok := u.CheckPassword(tc.password); ok != tc.ok {
+			t.Fatalf("CheckPassword(%q) with %q: expected %v", tc.password, tc.pass, tc.ok)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	if _, err := HashPassword("secret", 2); err == nil {
+		t.Fatal("expected invalid cost error")
+	}
+	hash, err := HashPassword("secret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHashedPassword(hash) || ValidatePassword(hash) != nil {
+		t.Fatalf("expected valid hash, got %s", hash)
+	}
+}
+
+func TestParseUsersHashed(t *testing.T) {
+	hash, _ := HashPassword("bar", bcrypt.MinCost)
+	users, err := ParseUsers([]byte(`{"foo:` + hash + `": [""], "ping:pong": [""]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if u.Name == "foo" && (u.Pass != hash || !u.CheckPassword("bar")) {
+			t.Fatalf("expected hashed password, got %s", u.Pass)
+		}
+		if u.Name == "ping" && !u.CheckPassword("pong") {
+			t.Fatal("expected plaintext password to keep working")
+		}
+	}
+	_, err = ParseUsers([]byte(`{"foo:$2a$10$truncated": [""]}`))
+	if err == nil || !strings.Contains(err.Error(), "for user foo") {
+		t.Fatalf("expected invalid hash error, got %v", err)
+	}
+}
+
+func TestParseUsersPolicy(t *testing.T) {
+	users, err := ParseUsers([]byte(`{
+		"foo:bar": {
+			"allow": ["^10\\.0\\.0\\.\\d+:\\d+$"],
+			"deny": ["^10\\.0\\.0\\.1:22$"],
+			"max-sessions": 2,
+			"max-channels": 8,
+			"sources": ["192.168.0.0/16", "10.1.2.3"],
+			"expires": "2030-01-01T00:00:00Z",
+			"socks": false,
+			"bandwidth": "2MB",
+			"quota-daily": "1GB",
+			"quota-monthly": "20GB"
+		},
+		"ping:pong": ["^localhost:80$"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var foo, ping *User
+	for _, u := range users {
+		switch u.Name {
+		case "foo":
+			foo = u
+		case "ping":
+			ping = u
+		}
+	}
+	if foo.MaxSessions != 2 || foo.MaxChannels != 8 || !foo.DenySocks || foo.DenyReverse || foo.Bandwidth != 2000000 || foo.QuotaDaily != 1e9 || foo.QuotaMonthly != 20e9 {
+		t.Fatalf("unexpected policy %+v", foo)
+	}
+	for addr, ok := range map[string]bool{
+		"10.0.0.2:22": true,
+		"10.0.0.1:80": true,
+		"10.0.0.1:22": false,
+		"10.0.1.1:80": false,
+	} {
+		if foo.HasAccess(addr) != ok {
+			t.Fatalf("HasAccess(%s): expected %v", addr, ok)
+		}
+	}
+	for ip, ok := range map[string]bool{
+		"192.168.1.1": true,
+		"10.1.2.3":    true,
+		"10.1.2.4":    false,
+		"::1":         false,
+	} {
+		if foo.HasSource(net.ParseIP(ip)) != ok {
+			t.Fatalf("HasSource(%s): expected %v", ip, ok)
+		}
+	}
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if foo.Expired(expires.Add(-time.Second)) || !foo.Expired(expires) {
+		t.Fatalf("expected expiry at %s, got %s", expires, foo.Expires)
+	}
+	//list users keep their unrestricted policy
+	if !ping.HasAccess("localhost:80") || !ping.HasSource(net.ParseIP("1.2.3.4")) || ping.Expired(time.Now()) || ping.DenySocks {
+		t.Fatalf("unexpected policy %+v", ping)
+	}
+	for _, invalid := range []string{
+		`{"foo:bar": {"allow": ["("]}}`,
+		`{"foo:bar": {"sources": ["10.0.0.0/33"]}}`,
+		`{"foo:bar": {"max-sessions": -1}}`,
+		`{"foo:bar": {"expires": "tomorrow"}}`,
+		`{"foo:bar": {"alow": [""]}}`,
+		`{"foo:bar": {"bandwidth": "fast"}}`,
+		`{"foo:bar": {"quota-daily": "-1GB"}}`,
+		`{"foo:bar": "*"}`,
+	} {
+		if _, err := ParseUsers([]byte(invalid)); err == nil || !strings.Contains(err.Error(), "user foo") {
+			t.Fatalf("%s: expected error, got %v", invalid, err)
+		}
+	}
+}
+
+func TestUserWithin(t *testing.T) {
+	u := &User{Name: "foo", Addrs: []*regexp.Regexp{regexp.MustCompile(`:80$`)}}
+	w := u.Within([]*regexp.Regexp{regexp.MustCompile(`^10\.`)})
+	for addr, ok := range map[string]bool{
+		"10.0.0.1:80":  true,
+		"10.0.0.1:22":  false,
+		"192.0.2.1:80": false,
+	} {
+		if w.HasAccess(addr) != ok {
+			t.Fatalf("HasAccess(%s): expected %v", addr, ok)
+		}
+	}
+	if !u.HasAccess("192.0.2.1:80") {
+		t.Fatal("expected the user to be unchanged")
+	}
+}
+
+func TestRejectPassword(t *testing.T) {
+	//unknown users are as slow to reject as hashed ones
+	hash, _ := HashPassword("secret", 0)
+	u := &User{Pass: hash}
+	start := time.Now()
+	u.CheckPassword("wrong")
+	known := time.Since(start)
+	RejectPassword("wrong") //generates the hash
+	start = time.Now()
+	RejectPassword("wrong")
+	if unknown := time.Since(start); unknown < known/2 {
+		t.Fatalf("expected unknown users to take about %s, took %s", known, unknown)
+	}
+}
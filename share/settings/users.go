@@ -1,12 +1,16 @@
 package settings
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/jpillora/chisel/share/cio"
@@ -73,6 +77,7 @@ type UserIndex struct {
 	*cio.Logger
 	*Users
 	configFile string
+	onReload   func()
 }
 
 // NewUserIndex creates a source for users
@@ -83,6 +88,12 @@ func NewUserIndex(logger *cio.Logger) *UserIndex {
 	}
 }
 
+// OnReload sets fn to be called after the users file
+// is reloaded, it must be set before LoadUsers
+func (u *UserIndex) OnReload(fn func()) {
+	u.onReload = fn
+}
+
 // LoadUsers is responsible for loading users from a file
 func (u *UserIndex) LoadUsers(configFile string) error {
 	u.configFile = configFile
@@ -98,11 +109,17 @@ func (u *UserIndex) LoadUsers(configFile string) error {
 
 // watchEvents is responsible for watching for updates to the file and reloading
 func (u *UserIndex) addWatchEvents() error {
+	return watchFile(u.Logger, u.configFile, "users", u.loadUserIndex)
+}
+
+// watchFile calls reload whenever the file of
+// the named configuration is written
+func watchFile(l *cio.Logger, path, name string, reload func() error) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
 	}
-	if err := watcher.Add(u.configFile); err != nil {
+	if err := watcher.Add(path); err != nil {
 		return err
 	}
 	go func() {
@@ -110,10 +127,10 @@ func (u *UserIndex) addWatchEvents() error {
 			if e.Op&fsnotify.Write != fsnotify.Write {
 				continue
 			}
-			if err := u.loadUserIndex(); err != nil {
-				u.Infof("Failed to reload the users configuration: %s", err)
+			if err := reload(); err != nil {
+				l.Logf(slog.LevelError, "Failed to reload the %s configuration: %s", name, err)
 			} else {
-				u.Debugf("Users configuration successfully reloaded from: %s", u.configFile)
+				l.Debugf("Successfully reloaded the %s configuration from: %s", name, path)
 			}
 		}
 	}()
@@ -129,31 +146,151 @@ func (u *UserIndex) loadUserIndex() error {
 	if err != nil {
 		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
 	}
-	var raw map[string][]string
+	users, err := ParseUsers(b)
+	if err != nil {
+		return err
+	}
+	//swap
+	u.Reset(users)
+	if u.onReload != nil {
+		u.onReload()
+	}
+	return nil
+}
+
+// ParseUsers parses users in the users.json format, a map of
+// "user:pass" keys to either lists of address regular
+// expressions, or objects with the policy of the user
+func ParseUsers(b []byte) ([]*User, error) {
+	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(b, &raw); err != nil {
-		return errors.New("Invalid JSON: " + err.Error())
+		return nil, errors.New("Invalid JSON: " + err.Error())
 	}
 	users := []*User{}
-	for auth, remotes := range raw {
+	for auth, v := range raw {
 		user := &User{}
 		user.Name, user.Pass = ParseAuth(auth)
 		if user.Name == "" {
-			return errors.New("Invalid user:pass string")
+			return nil, errors.New("Invalid user:pass string")
+		}
+		if err := ValidatePassword(user.Pass); err != nil {
+			return nil, fmt.Errorf("%s for user %s", err, user.Name)
 		}
-		for _, r := range remotes {
-			if r == "" || r == "*" {
-				user.Addrs = append(user.Addrs, UserAllowAll)
+		var err error
+		if bytes.HasPrefix(bytes.TrimSpace(v), []byte("{")) {
+			err = parseUserPolicy(user, v)
+		} else {
+			var remotes []string
+			if err = json.Unmarshal(v, &remotes); err != nil {
+				err = errors.New("expected a list of addresses or an object")
 			} else {
-				re, err := regexp.Compile(r)
-				if err != nil {
-					return errors.New("Invalid address regex")
-				}
-				user.Addrs = append(user.Addrs, re)
+				user.Addrs, err = parseAddrs(remotes)
 			}
 		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid user %s: %s", user.Name, err)
+		}
 		users = append(users, user)
 	}
-	//swap
-	u.Reset(users)
+	return users, nil
+}
+
+// userPolicy is the object form of a user in users.json
+type userPolicy struct {
+	Allow        []string  `json:"allow"`
+	Deny         []string  `json:"deny"`
+	MaxSessions  int       `json:"max-sessions"`
+	MaxChannels  int       `json:"max-channels"`
+	Sources      []string  `json:"sources"`
+	Expires      time.Time `json:"expires"`
+	Socks        *bool     `json:"socks"`
+	Reverse      *bool     `json:"reverse"`
+	Bandwidth    string    `json:"bandwidth"`
+	QuotaDaily   string    `json:"quota-daily"`
+	QuotaMonthly string    `json:"quota-monthly"`
+}
+
+func parseUserPolicy(user *User, b []byte) error {
+	p := userPolicy{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return err
+	}
+	var err error
+	if user.Addrs, err = parseAddrs(p.Allow); err != nil {
+		return err
+	}
+	if user.Deny, err = parseAddrs(p.Deny); err != nil {
+		return err
+	}
+	if p.MaxSessions < 0 || p.MaxChannels < 0 {
+		return errors.New("limits must not be negative")
+	}
+	user.MaxSessions = p.MaxSessions
+	user.MaxChannels = p.MaxChannels
+	for _, s := range p.Sources {
+		n, err := ParseSource(s)
+		if err != nil {
+			return err
+		}
+		user.Sources = append(user.Sources, n)
+	}
+	user.Expires = p.Expires
+	user.DenySocks = p.Socks != nil && !*p.Socks
+	user.DenyReverse = p.Reverse != nil && !*p.Reverse
+	for _, size := range []struct {
+		name string
+		s    string
+		n    *int64
+	}{
+		{"bandwidth", p.Bandwidth, &user.Bandwidth},
+		{"daily quota", p.QuotaDaily, &user.QuotaDaily},
+		{"monthly quota", p.QuotaMonthly, &user.QuotaMonthly},
+	} {
+		if size.s == "" {
+			continue
+		}
+		n, err := ParseSize(size.s)
+		if err != nil {
+			return fmt.Errorf("Invalid %s %q", size.name, size.s)
+		}
+		*size.n = n
+	}
 	return nil
 }
+
+// parseAddrs compiles address regular expressions,
+// where "" and "*" match all addresses
+func parseAddrs(remotes []string) ([]*regexp.Regexp, error) {
+	var addrs []*regexp.Regexp
+	for _, r := range remotes {
+		if r == "" || r == "*" {
+			addrs = append(addrs, UserAllowAll)
+		} else {
+			re, err := regexp.Compile(r)
+			if err != nil {
+				return nil, errors.New("Invalid address regex")
+			}
+			addrs = append(addrs, re)
+		}
+	}
+	return addrs, nil
+}
+
+// ParseSource parses a network in CIDR notation,
+// or a single IP address
+func ParseSource(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid source %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,168 @@
+package traffic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Obfuscation controls the randomization applied to tunnelled traffic.
+// The zero value disables all randomization.
+type Obfuscation struct {
+	// WriteDelayMin and WriteDelayMax bound the random delay before each
+	// write to the connection of any transport
+	WriteDelayMin, WriteDelayMax time.Duration
+	// ChunkSizeMin and ChunkSizeMax bound the random chunk sizes used when
+	// piping data, zero disables chunking
+	ChunkSizeMin, ChunkSizeMax int
+	// ChunkDelayMin and ChunkDelayMax bound the random delay between chunks
+	ChunkDelayMin, ChunkDelayMax time.Duration
+	// KeepAliveJitter randomizes keepalive intervals by ±(fraction) of the
+	// interval, e.g. 0.3 for ±30%
+	KeepAliveJitter float64
+}
+
+// Obfuscation levels
+var (
+	ObfuscationOff    = Obfuscation{}
+	ObfuscationLow    = Obfuscation{0, 5 * time.Millisecond, 16 * 1024, 32 * 1024, 0, 1 * time.Millisecond, 0.10}
+	ObfuscationMedium = Obfuscation{0, 30 * time.Millisecond, 4 * 1024, 32 * 1024, 0, 5 * time.Millisecond, 0.20}
+	ObfuscationHigh   = Obfuscation{0, 100 * time.Millisecond, 1 * 1024, 32 * 1024, 0, 20 * time.Millisecond, 0.30}
+)
+
+// DefaultObfuscation is the level used when none is specified
+const DefaultObfuscation = "high"
+
+var levels = map[string]Obfuscation{
+	"off":    ObfuscationOff,
+	"low":    ObfuscationLow,
+	"medium": ObfuscationMedium,
+	"high":   ObfuscationHigh,
+}
+
+// maxChunkSize bounds the pipe buffers
+const maxChunkSize = 1024 * 1024
+
+// ParseObfuscation parses an obfuscation profile in the form:
+//
+//	<level>[,<setting>=<min>-<max>...]
+//
+// where <level> is one of off, low, medium or high (defaults to high),
+// and the optional settings override the values of that level:
+//
+//	write-delay=0-50ms
+//	chunk-size=4096-16K
+//	chunk-delay=0-5ms
+//	keepalive-jitter=0.2 (or 20%)
+func ParseObfuscation(s string) (Obfuscation, error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	level := DefaultObfuscation
+	if parts[0] != "" && !strings.Contains(parts[0], "=") {
+		level = strings.ToLower(parts[0])
+		parts = parts[1:]
+	}
+	o, ok := levels[level]
+	if !ok {
+		return o, fmt.Errorf("unknown obfuscation level '%s' (expected off, low, medium or high)", level)
+	}
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return o, fmt.Errorf("invalid obfuscation setting '%s'", p)
+		}
+		var err error
+		switch kv[0] {
+		case "write-delay":
+			o.WriteDelayMin, o.WriteDelayMax, err = parseDurationRange(kv[1])
+		case "chunk-delay":
+			o.ChunkDelayMin, o.ChunkDelayMax, err = parseDurationRange(kv[1])
+		case "chunk-size":
+			o.ChunkSizeMin, o.ChunkSizeMax, err = parseSizeRange(kv[1])
+		case "keepalive-jitter":
+			o.KeepAliveJitter, err = parseFraction(kv[1])
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return o, fmt.Errorf("invalid obfuscation setting '%s': %s", p, err)
+		}
+	}
+	return o, o.Validate()
+}
+
+// Validate checks the ranges of the obfuscation settings
+func (o Obfuscation) Validate() error {
+	if o.WriteDelayMin < 0 || o.WriteDelayMin > o.WriteDelayMax {
+		return fmt.Errorf("invalid obfuscation write delay range")
+	}
+	if o.ChunkDelayMin < 0 || o.ChunkDelayMin > o.ChunkDelayMax {
+		return fmt.Errorf("invalid obfuscation chunk delay range")
+	}
+	if o.ChunkSizeMax != 0 && (o.ChunkSizeMin <= 0 || o.ChunkSizeMin > o.ChunkSizeMax || o.ChunkSizeMax > maxChunkSize) {
+		return fmt.Errorf("invalid obfuscation chunk size range (1 to %d bytes)", maxChunkSize)
+	}
+	if o.KeepAliveJitter < 0 || o.KeepAliveJitter >= 1 {
+		return fmt.Errorf("invalid obfuscation keepalive jitter (0 to 0.99)")
+	}
+	return nil
+}
+
+func splitRange(s string) (string, string) {
+	if i := strings.Index(s, "-"); i > 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, s
+}
+
+func parseDurationRange(s string) (time.Duration, time.Duration, error) {
+	a, b := splitRange(s)
+	min, err := time.ParseDuration(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := time.ParseDuration(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return min, max, nil
+}
+
+func parseSizeRange(s string) (int, int, error) {
+	a, b := splitRange(s)
+	min, err := parseSize(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := parseSize(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return min, max, nil
+}
+
+func parseSize(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToUpper(s), "B")
+	mult := 1
+	if strings.HasSuffix(s, "K") {
+		s = strings.TrimSuffix(s, "K")
+		mult = 1024
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+func parseFraction(s string) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		return f / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,44 @@
+package traffic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseObfuscation(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Obfuscation
+	}{
+		{"", ObfuscationHigh},
+		{"off", ObfuscationOff},
+		{"Medium", ObfuscationMedium},
+		{"low,write-delay=0-10ms", Obfuscation{0, 10 * time.Millisecond, 16 * 1024, 32 * 1024, 0, 1 * time.Millisecond, 0.10}},
+		{"off,chunk-size=4K-16384,chunk-delay=1ms", Obfuscation{ChunkSizeMin: 4096, ChunkSizeMax: 16384, ChunkDelayMin: time.Millisecond, ChunkDelayMax: time.Millisecond}},
+		{"keepalive-jitter=5%", Obfuscation{0, 100 * time.Millisecond, 1 * 1024, 32 * 1024, 0, 20 * time.Millisecond, 0.05}},
+	} {
+		got, err := ParseObfuscation(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{
+		"extreme",
+		"low,foo=1",
+		"low,write-delay",
+		"low,write-delay=10ms-1ms",
+		"low,write-delay=5ms-0",
+		"low,chunk-delay=5ms-0",
+		"low,chunk-size=0-1K",
+		"low,chunk-size=1-4096K",
+		"low,keepalive-jitter=1.5",
+	} {
+		if _, err := ParseObfuscation(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package traffic
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +22,23 @@ const (
 	PatternRandom
 )
 
+var patternNames = map[TrafficPattern]string{
+	PatternHTTPLike: "http",
+	PatternSSHLike:  "ssh",
+	PatternRandom:   "random",
+}
+
+// String returns the name of the pattern
+func (p TrafficPattern) String() string {
+	if n, ok := patternNames[p]; ok {
+		return n
+	}
+	return fmt.Sprintf("pattern(%d)", int(p))
+}
+
+// Sender transmits a single packet of cover traffic
+type Sender func(payload []byte) error
+
 // Simulator simulates realistic traffic patterns
 type Simulator struct {
 	pattern     TrafficPattern
@@ -33,6 +54,11 @@ type Simulator struct {
 	burstMode   bool
 	burstCount  int
 	idlePeriod  time.Duration
+	// Cover traffic output
+	send        Sender
+	idle        func() bool
+	sentPackets int64
+	sentBytes   int64
 }
 
 // NewSimulator creates a new traffic simulator
@@ -53,12 +79,29 @@ func NewSimulator(pattern TrafficPattern) *Simulator {
 	}
 }
 
+// SetSender sets the function used to transmit cover traffic,
+// without one the simulator only follows the pattern's timing
+func (s *Simulator) SetSender(send Sender) {
+	s.send = send
+}
+
+// SetIdle sets the function reporting whether the connection is
+// idle, cover traffic is only sent while it returns true
+func (s *Simulator) SetIdle(idle func() bool) {
+	s.idle = idle
+}
+
+// Stats returns the number of cover packets and bytes sent
+func (s *Simulator) Stats() (packets, bytes int64) {
+	return atomic.LoadInt64(&s.sentPackets), atomic.LoadInt64(&s.sentBytes)
+}
+
 // Start starts the traffic simulator
 func (s *Simulator) Start() {
-	if !s.enabled {
+	if !s.enabled || s.intensity == 0 {
 		return
 	}
-	
+
 	go s.run()
 }
 
@@ -72,40 +115,57 @@ func (s *Simulator) Stop() {
 // run executes the traffic simulation pattern
 func (s *Simulator) run() {
 	for {
+		interval, size := s.simulatePattern()
 		select {
 		case <-s.ctx.Done():
 			return
-		default:
-			s.simulatePattern()
+		case <-time.After(interval):
+		}
+		if s.send == nil || (s.idle != nil && !s.idle()) {
+			continue
+		}
+		payload := make([]byte, size)
+		s.rng.Read(payload)
+		if err := s.send(payload); err != nil {
+			return
 		}
+		atomic.AddInt64(&s.sentPackets, 1)
+		atomic.AddInt64(&s.sentBytes, int64(size))
 	}
 }
 
-// simulatePattern simulates traffic based on the selected pattern
-func (s *Simulator) simulatePattern() {
+// simulatePattern returns the interval until the next packet
+// and its size, based on the selected pattern
+func (s *Simulator) simulatePattern() (time.Duration, int) {
 	var interval time.Duration
-	
+	var size int
+
 	switch s.pattern {
 	case PatternHTTPLike:
 		// HTTP-like: Bursty traffic with request/response patterns
 		interval = s.simulateHTTPLike()
-		
+		// Requests and responses: 200-1500 bytes
+		size = 200 + s.rng.Intn(1300)
+
 	case PatternSSHLike:
 		// SSH-like: Steady low-volume traffic with occasional bursts
 		interval = s.simulateSSHLike()
-		
-	case PatternRandom:
+		// Keystrokes and echoes: 32-128 bytes
+		size = 32 + s.rng.Intn(96)
+
+	default:
 		// Random: Completely random intervals
 		interval = s.simulateRandom()
+		size = 16 + s.rng.Intn(1008)
 	}
-	
-	// Scale by intensity (0-100)
-	actualInterval := time.Duration(float64(interval) * (float64(s.intensity) / 100.0))
+
+	// Scale by intensity (1-100), lower intensities send less often
+	actualInterval := time.Duration(float64(interval) * (100.0 / float64(s.intensity)))
 	if actualInterval < 50*time.Millisecond {
 		actualInterval = 50 * time.Millisecond
 	}
-	
-	time.Sleep(actualInterval)
+
+	return actualInterval, size
 }
 
 // simulateHTTPLike simulates HTTP-like traffic patterns
@@ -176,7 +236,8 @@ func (s *Simulator) simulateRandom() time.Duration {
 	return time.Duration(s.rng.Int63n(int64(5*time.Second))) + 100*time.Millisecond
 }
 
-// SetIntensity sets the simulation intensity (0-100)
+// SetIntensity sets the simulation intensity (0-100),
+// where 0 disables the simulator
 func (s *Simulator) SetIntensity(intensity int) {
 	if intensity < 0 {
 		intensity = 0
@@ -205,3 +266,60 @@ func (s *Simulator) SetPattern(pattern TrafficPattern) {
 	s.pattern = pattern
 }
 
+
+// CoverTraffic configures a Simulator used as cover traffic.
+// The zero value disables cover traffic.
+type CoverTraffic struct {
+	Pattern   TrafficPattern
+	Intensity int
+}
+
+// Enabled reports whether cover traffic should be sent
+func (c CoverTraffic) Enabled() bool {
+	return c.Intensity > 0
+}
+
+// NewSimulator creates a Simulator for this configuration
+func (c CoverTraffic) NewSimulator() *Simulator {
+	s := NewSimulator(c.Pattern)
+	s.SetIntensity(c.Intensity)
+	return s
+}
+
+// ParseCoverTraffic parses a cover traffic setting in the form:
+//
+//	<pattern>[,intensity=<1-100>]
+//
+// where <pattern> is one of http, ssh or random, and intensity
+// defaults to 100. An empty string or "off" disables cover traffic.
+func ParseCoverTraffic(s string) (CoverTraffic, error) {
+	c := CoverTraffic{}
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return c, nil
+	}
+	parts := strings.Split(s, ",")
+	found := false
+	for p, n := range patternNames {
+		if strings.EqualFold(parts[0], n) {
+			c.Pattern = p
+			found = true
+		}
+	}
+	if !found {
+		return c, fmt.Errorf("unknown cover traffic pattern '%s' (expected http, ssh or random)", parts[0])
+	}
+	c.Intensity = 100
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 || kv[0] != "intensity" {
+			return c, fmt.Errorf("invalid cover traffic setting '%s'", p)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(kv[1], "%"))
+		if err != nil || n < 1 || n > 100 {
+			return c, fmt.Errorf("invalid cover traffic intensity '%s' (1 to 100)", kv[1])
+		}
+		c.Intensity = n
+	}
+	return c, nil
+}
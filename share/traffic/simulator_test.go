@@ -0,0 +1,73 @@
+package traffic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCoverTraffic(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want CoverTraffic
+	}{
+		{"", CoverTraffic{}},
+		{"off", CoverTraffic{}},
+		{"http", CoverTraffic{PatternHTTPLike, 100}},
+		{"SSH,intensity=25", CoverTraffic{PatternSSHLike, 25}},
+		{"random,intensity=50%", CoverTraffic{PatternRandom, 50}},
+	} {
+		got, err := ParseCoverTraffic(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{"ftp", "ssh,intensity=0", "ssh,intensity=101", "ssh,rate=5"} {
+		if _, err := ParseCoverTraffic(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestSimulatorSends(t *testing.T) {
+	sent := make(chan []byte, 1)
+	s := NewSimulator(PatternSSHLike)
+	s.SetSender(func(payload []byte) error {
+		select {
+		case sent <- payload:
+		default:
+		}
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+	select {
+	case b := <-sent:
+		if len(b) < 32 || len(b) > 128 {
+			t.Fatalf("unexpected ssh-like packet size %d", len(b))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no cover traffic sent")
+	}
+	if packets, _ := s.Stats(); packets == 0 {
+		time.Sleep(10 * time.Millisecond)
+		if packets, _ = s.Stats(); packets == 0 {
+			t.Fatal("expected packets to be counted")
+		}
+	}
+}
+
+func TestSimulatorIdle(t *testing.T) {
+	s := NewSimulator(PatternRandom)
+	s.SetIdle(func() bool { return false })
+	s.SetSender(func(payload []byte) error {
+		t.Error("sent cover traffic while busy")
+		return nil
+	})
+	s.Start()
+	time.Sleep(300 * time.Millisecond)
+	s.Stop()
+}
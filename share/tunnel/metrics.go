@@ -0,0 +1,221 @@
+package tunnel
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jpillora/chisel/share/metrics"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+//The remote label of channels is the configured remote
+//of a Tunnel, or one of these buckets, so that peers
+//cannot add series with the destinations they choose
+const (
+	//labelSocks is the remote of SOCKS channels
+	labelSocks = "socks"
+	//labelDirect is the remote of channels which are
+	//not those of a configured remote, such as dialed ones
+	labelDirect = "direct"
+	//labelDenied is the remote of rejected channels
+	labelDenied = "denied"
+)
+
+//Metrics of channels, shared by all Tunnels of a process.
+//A nil *Metrics disables collection.
+type Metrics struct {
+	opened, failed, active *metrics.Vec
+	sent, received         *metrics.Vec
+	udpDropped             *metrics.Vec
+	coverPackets           *metrics.Vec
+	coverBytes             *metrics.Vec
+	//Tunnels using each remote label
+	remotesMut sync.Mutex
+	remotes    map[string]int
+}
+
+//NewMetrics registers the channel metrics with the given registry
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		opened:       r.Counter("chisel_channels_opened_total", "Channels opened, by remote.", "remote"),
+		failed:       r.Counter("chisel_channels_failed_total", "Channels which were rejected or could not connect, by remote.", "remote"),
+		active:       r.Gauge("chisel_channels_active", "Channels currently open, by remote.", "remote"),
+		sent:         r.Counter("chisel_sent_bytes_total", "Bytes sent into channels, by user and remote.", "user", "remote"),
+		received:     r.Counter("chisel_received_bytes_total", "Bytes received from channels, by user and remote.", "user", "remote"),
+		udpDropped:   r.Counter("chisel_udp_dropped_packets_total", "UDP packets dropped due to a full queue, by remote.", "remote"),
+		coverPackets: r.Counter("chisel_cover_packets_total", "Cover traffic packets sent."),
+		coverBytes:   r.Counter("chisel_cover_bytes_total", "Cover traffic payload bytes sent."),
+		remotes:      map[string]int{},
+	}
+}
+
+//track counts a Tunnel using the remote label
+func (m *Metrics) track(remote string) {
+	m.remotesMut.Lock()
+	defer m.remotesMut.Unlock()
+	m.remotes[remote]++
+}
+
+//untrack reverses track, deleting the series of the
+//remote label once no Tunnel uses it
+func (m *Metrics) untrack(remote string) {
+	m.remotesMut.Lock()
+	defer m.remotesMut.Unlock()
+	if m.remotes[remote]--; m.remotes[remote] > 0 {
+		return
+	}
+	delete(m.remotes, remote)
+	for _, v := range []*metrics.Vec{m.opened, m.failed, m.active, m.sent, m.received, m.udpDropped} {
+		v.Delete("remote", remote)
+	}
+}
+
+//MetricRemotes adds and removes configured remotes of the
+//Tunnel, which label the metrics of their channels. Other
+//channels are labelled by buckets. The series of remotes
+//are deleted once removed from all Tunnels.
+func (t *Tunnel) MetricRemotes(add, remove []*settings.Remote) {
+	if t.Metrics == nil {
+		return
+	}
+	t.labelsMut.Lock()
+	defer t.labelsMut.Unlock()
+	for _, r := range remove {
+		label := r.String()
+		if _, ok := t.labels[label]; ok {
+			delete(t.labels, label)
+			t.Metrics.untrack(label)
+		}
+	}
+	for _, r := range add {
+		label := r.String()
+		if _, ok := t.labels[label]; !ok {
+			t.labels[label] = r
+			t.Metrics.track(label)
+		}
+	}
+}
+
+//CloseMetrics removes all remotes of MetricRemotes,
+//once the Tunnel is no longer used
+func (t *Tunnel) CloseMetrics() {
+	if t.Metrics == nil {
+		return
+	}
+	t.labelsMut.Lock()
+	defer t.labelsMut.Unlock()
+	for label := range t.labels {
+		t.Metrics.untrack(label)
+	}
+	t.labels = map[string]*settings.Remote{}
+}
+
+//remoteLabel returns the label of the metrics of an outbound
+//channel to the destination, the configured remote with that
+//destination (the first, when several have it)
+func (t *Tunnel) remoteLabel(dst string) string {
+	if dst == "socks" {
+		return labelSocks
+	}
+	t.labelsMut.RLock()
+	defer t.labelsMut.RUnlock()
+	match := ""
+	for label, r := range t.labels {
+		rdst := r.Remote()
+		if r.RemoteProto == "udp" {
+			rdst += "/udp"
+		}
+		if rdst == dst && (match == "" || label < match) {
+			match = label
+		}
+	}
+	if match == "" {
+		return labelDirect
+	}
+	return match
+}
+
+//tunnelMetrics labels the Metrics with the user of a Tunnel,
+//and counts the open channels of the Tunnel
+type tunnelMetrics struct {
+	*Metrics
+	user     string
+	channels *int32
+}
+
+//open counts an open channel, until the returned
+//func is called once the channel is closed
+func (m tunnelMetrics) open(remote string) func() {
+	if m.channels != nil {
+		atomic.AddInt32(m.channels, 1)
+	}
+	var active *metrics.Value
+	if m.Metrics != nil {
+		m.opened.With(remote).Inc()
+		active = m.active.With(remote)
+		active.Inc()
+	}
+	return func() {
+		if m.channels != nil {
+			atomic.AddInt32(m.channels, -1)
+		}
+		active.Dec()
+	}
+}
+
+func (m tunnelMetrics) fail(remote string) {
+	if m.Metrics != nil {
+		m.failed.With(remote).Inc()
+	}
+}
+
+func (m tunnelMetrics) bytes(remote string, sent, received int64) {
+	if m.Metrics != nil {
+		m.sent.With(m.user, remote).Add(sent)
+		m.received.With(m.user, remote).Add(received)
+	}
+}
+
+//meter counts the bytes written into (sent) and read
+//from (received) the given channel, as they happen
+func (m tunnelMetrics) meter(remote string, ch io.ReadWriteCloser) io.ReadWriteCloser {
+	if m.Metrics == nil {
+		return ch
+	}
+	return &meterChannel{
+		ReadWriteCloser: ch,
+		sent:            m.sent.With(m.user, remote),
+		received:        m.received.With(m.user, remote),
+	}
+}
+
+type meterChannel struct {
+	io.ReadWriteCloser
+	sent, received *metrics.Value
+}
+
+func (c *meterChannel) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *meterChannel) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+func (m tunnelMetrics) drop(remote string) {
+	if m.Metrics != nil {
+		m.udpDropped.With(remote).Inc()
+	}
+}
+
+func (m tunnelMetrics) cover(n int) {
+	if m.Metrics != nil {
+		m.coverPackets.With().Inc()
+		m.coverBytes.With().Add(int64(n))
+	}
+}
@@ -8,13 +8,18 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/armon/go-socks5"
+	"github.com/jpillora/chisel/share/audit"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/egress"
 	"github.com/jpillora/chisel/share/settings"
+	"github.com/jpillora/chisel/share/traffic"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 )
@@ -26,6 +31,36 @@ type Config struct {
 	Outbound  bool
 	Socks     bool
 	KeepAlive time.Duration
+	//User, when set, restricts outbound
+	//channels to the user's addresses
+	User *settings.User
+	//Egress, when set, restricts the destinations
+	//dialed by outbound channels
+	Egress *egress.Policy
+	//Limit, when set, limits the bandwidth
+	//of all channels of the Tunnel
+	Limit *cio.Limiter
+	//Accounting, when set, counts the bytes of
+	//the channels of the Tunnel, and may refuse
+	//to open more channels
+	Accounting Accounting
+	//Audit, when set, records each channel and
+	//each denied channel in the audit log
+	Audit func(e audit.Event)
+	//Listener, when set, may return an in-process
+	//Listener for an outbound address
+	Listener func(addr string) *Listener
+	//Metrics, when set, collects channel metrics
+	Metrics *Metrics
+	//Obfuscation randomizes the timing and chunking
+	//of piped data and the keepalive intervals
+	Obfuscation traffic.Obfuscation
+	//Cover, when enabled, sends cover traffic
+	//while no channels are open
+	Cover traffic.CoverTraffic
+	//Remotes, when set, handles requests of the
+	//peer to add and remove its remotes
+	Remotes func(u *settings.RemotesUpdate) error
 }
 
 //Tunnel represents an SSH tunnel with proxy capabilities.
@@ -37,53 +72,107 @@ type Config struct {
 //communicates with the endpoint and returns the response.
 type Tunnel struct {
 	Config
-	//ssh connection
-	activeConnMut  sync.RWMutex
-	activatingConn waitGroup
-	activeConn     ssh.Conn
+	//ssh connections, see BindSSH and BindExtraSSH
+	connsMut          sync.RWMutex
+	activatingConn    waitGroup
+	activatingPrimary waitGroup
+	conns             []*poolConn
 	//proxies
+	proxiesMut sync.Mutex
+	proxies    map[string]*boundProxy
 	proxyCount int
 	//internals
 	connStats   cnet.ConnCount
-	socksServer *socks5.Server
-	// Enhanced connection management
-	connPool     []ssh.Conn
-	connPoolMut  sync.RWMutex
-	maxPoolSize  int
-	healthCheck  *time.Ticker
-	lastActivity time.Time
+	outboundMut sync.RWMutex
+	//open channels
+	channels int32
+	//channels counted against the limit of the user
+	userChannels int32
+	//round trip of the last keepalive ping
+	lastPing lastPing
+	//configured remotes labelling the metrics
+	//of channels, by label
+	labelsMut sync.RWMutex
+	labels    map[string]*settings.Remote
 }
 
 //New Tunnel from the given Config
 func New(c Config) *Tunnel {
 	c.Logger = c.Logger.Fork("tun")
 	t := &Tunnel{
-		Config:      c,
-		maxPoolSize: 5, // Maximum connection pool size
-		connPool:    make([]ssh.Conn, 0),
+		Config:  c,
+		proxies: map[string]*boundProxy{},
+		labels:  map[string]*settings.Remote{},
 	}
 	t.activatingConn.Add(1)
+	t.activatingPrimary.Add(1)
 	//setup socks server (not listening on any port!)
 	extra := ""
 	if c.Socks {
-		sl := log.New(io.Discard, "", 0)
-		if t.Logger.Debug {
-			sl = log.New(os.Stdout, "[socks]", log.Ldate|log.Ltime)
-		}
-		t.socksServer, _ = socks5.New(&socks5.Config{Logger: sl})
 		extra += " (SOCKS enabled)"
 	}
-	// Start health check for connection pool
-	if c.KeepAlive > 0 {
-		t.healthCheck = time.NewTicker(c.KeepAlive / 2)
-		go t.connectionPoolHealthCheck()
-	}
 	t.Debugf("Created%s", extra)
 	return t
 }
 
-//BindSSH provides an active SSH for use for tunnelling
+//newSocksServer creates the SOCKS server of a
+//channel, which records its request in req
+func (t *Tunnel) newSocksServer(req *socksRequest) *socks5.Server {
+	sl := log.New(io.Discard, "", 0)
+	if t.Logger.IsDebug() {
+		sl = log.New(os.Stdout, "[socks]", log.Ldate|log.Ltime)
+	}
+	c := &socks5.Config{Logger: sl, Rules: socksRules{t, req}}
+	if t.Egress != nil {
+		//names are resolved by the egress policy
+		c.Resolver = socksResolver{}
+		c.Dial = t.dial
+	}
+	s, _ := socks5.New(c)
+	return s
+}
+
+//EnableOutbound allows outbound connections, and
+//optionally SOCKS, on a Tunnel which is running.
+//The returned func restores the previous state.
+func (t *Tunnel) EnableOutbound(socks bool) (restore func()) {
+	t.outboundMut.Lock()
+	defer t.outboundMut.Unlock()
+	outbound, wasSocks := t.Config.Outbound, t.Config.Socks
+	t.Config.Outbound = true
+	if socks {
+		t.Config.Socks = true
+	}
+	return func() {
+		t.outboundMut.Lock()
+		defer t.outboundMut.Unlock()
+		t.Config.Outbound, t.Config.Socks = outbound, wasSocks
+	}
+}
+
+//outbound returns whether outbound connections,
+//and SOCKS connections, are allowed
+func (t *Tunnel) outbound() (bool, bool) {
+	t.outboundMut.RLock()
+	defer t.outboundMut.RUnlock()
+	return t.Config.Outbound, t.Config.Socks
+}
+
+//BindSSH provides an active SSH for use for tunnelling,
+//and blocks until it is closed. It is the primary connection,
+//over which requests are sent to the other end.
 func (t *Tunnel) BindSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel) error {
+	return t.bindSSH(ctx, &poolConn{Conn: c, primary: true}, reqs, chans)
+}
+
+//BindExtraSSH provides an additional SSH connection, to the
+//same end as the one of BindSSH, and blocks until it is closed.
+//New channels are opened over the least loaded connection.
+func (t *Tunnel) BindExtraSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel) error {
+	return t.bindSSH(ctx, &poolConn{Conn: c}, reqs, chans)
+}
+
+func (t *Tunnel) bindSSH(ctx context.Context, c *poolConn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel) error {
 	//link ctx to ssh-conn
 	go func() {
 		<-ctx.Done()
@@ -91,43 +180,203 @@ func (t *Tunnel) BindSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Reque
 			t.Debugf("SSH cancelled")
 		}
 		t.activatingConn.DoneAll()
+		t.activatingPrimary.DoneAll()
 	}()
 	//mark active and unblock
-	t.activeConnMut.Lock()
-	if t.activeConn != nil {
+	t.connsMut.Lock()
+	if c.primary && t.primary() != nil {
 		panic("double bind ssh")
 	}
-	t.activeConn = c
-	t.activeConnMut.Unlock()
+	t.conns = append(t.conns, c)
+	t.connsMut.Unlock()
 	t.activatingConn.Done()
+	if c.primary {
+		t.activatingPrimary.Done()
+	}
 	//optional keepalive loop against this connection
 	if t.Config.KeepAlive > 0 {
 		go t.keepAliveLoop(c)
 	}
+	//optional cover traffic against the primary connection
+	if t.Cover.Enabled() && c.primary {
+		sim := t.startCover(c)
+		defer sim.Stop()
+	}
 	//block until closed
 	go t.handleSSHRequests(reqs)
 	go t.handleSSHChannels(chans)
 	t.Debugf("SSH connected")
-	t.updateLastActivity()
 	err := c.Wait()
 	t.Debugf("SSH disconnected")
 	//mark inactive and block
-	t.activatingConn.Add(1)
-	t.activeConnMut.Lock()
-	t.activeConn = nil
-	t.activeConnMut.Unlock()
+	t.connsMut.Lock()
+	for i, cc := range t.conns {
+		if cc == c {
+			t.conns = append(t.conns[:i], t.conns[i+1:]...)
+			break
+		}
+	}
+	if len(t.conns) == 0 {
+		t.activatingConn.Add(1)
+	}
+	if c.primary {
+		t.activatingPrimary.Add(1)
+	}
+	t.connsMut.Unlock()
 	return err
 }
 
-//getSSH blocks while connecting
+//metrics labelled with the user of this tunnel
+func (t *Tunnel) metrics() tunnelMetrics {
+	m := tunnelMetrics{Metrics: t.Config.Metrics, channels: &t.channels}
+	if t.User != nil {
+		m.user = t.User.Name
+	}
+	return m
+}
+
+//acquireChannel reserves a channel within the
+//channel limit of the user, if any
+func (t *Tunnel) acquireChannel() bool {
+	if t.User == nil || t.User.MaxChannels == 0 {
+		return true
+	}
+	if atomic.AddInt32(&t.userChannels, 1) > int32(t.User.MaxChannels) {
+		atomic.AddInt32(&t.userChannels, -1)
+		return false
+	}
+	return true
+}
+
+//releaseChannel releases a channel reserved by acquireChannel
+func (t *Tunnel) releaseChannel() {
+	if t.User != nil && t.User.MaxChannels > 0 {
+		atomic.AddInt32(&t.userChannels, -1)
+	}
+}
+
+//pipe copies data between src and dst using
+//the configured obfuscation, limited by ls
+func (t *Tunnel) pipe(src, dst io.ReadWriteCloser, ls ...*cio.Limiter) (int64, int64) {
+	return cio.PipeWith(src, dst, t.Obfuscation, ls...)
+}
+
+//limiter returns the bandwidth limit of the Tunnel
+func (t *Tunnel) limiter() *cio.Limiter {
+	return t.Limit
+}
+
+//Accounting counts the bytes of the channels of a Tunnel
+type Accounting interface {
+	//Admit returns an error when no more
+	//channels may be opened
+	Admit() error
+	//Add counts n bytes sent or received
+	Add(n int64)
+}
+
+//admit checks the Accounting before opening a channel
+func (t *Tunnel) admit() error {
+	if t.Accounting == nil {
+		return nil
+	}
+	return t.Accounting.Admit()
+}
+
+//account counts the bytes read from and written
+//to ch with the Accounting of the Tunnel
+func (t *Tunnel) account(ch io.ReadWriteCloser) io.ReadWriteCloser {
+	if t.Accounting == nil {
+		return ch
+	}
+	return &accountChannel{ReadWriteCloser: ch, a: t.Accounting}
+}
+
+type accountChannel struct {
+	io.ReadWriteCloser
+	a Accounting
+}
+
+func (c *accountChannel) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.a.Add(int64(n))
+	return n, err
+}
+
+func (c *accountChannel) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.a.Add(int64(n))
+	return n, err
+}
+
+//audit records the event in the audit log
+func (t *Tunnel) audit(e audit.Event) {
+	if t.Audit != nil {
+		t.Audit(e)
+	}
+}
+
+//countChannel counts the bytes written into (sent)
+//and read from (received) a channel
+type countChannel struct {
+	io.ReadWriteCloser
+	sent, received int64
+}
+
+func (c *countChannel) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.received, int64(n))
+	return n, err
+}
+
+func (c *countChannel) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.sent, int64(n))
+	return n, err
+}
+
+//closed returns the event of the counted
+//channel, which was opened at the given time
+func (c *countChannel) closed(dst string, opened time.Time, err error) audit.Event {
+	e := audit.Event{
+		Type:        audit.Channel,
+		Destination: dst,
+		Sent:        atomic.LoadInt64(&c.sent),
+		Received:    atomic.LoadInt64(&c.received),
+		DurationMs:  time.Since(opened).Milliseconds(),
+	}
+	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
+		e.Reason = err.Error()
+	}
+	return e
+}
+
+//getSSH blocks while connecting, and returns the
+//connection with the fewest channels opened over it
 func (t *Tunnel) getSSH(ctx context.Context) ssh.Conn {
+	return t.waitSSH(ctx, &t.activatingConn, t.leastLoaded)
+}
+
+//getPrimary blocks while connecting, and returns
+//the primary connection
+func (t *Tunnel) getPrimary(ctx context.Context) ssh.Conn {
+	return t.waitSSH(ctx, &t.activatingPrimary, t.primary)
+}
+
+//WaitPrimary blocks until the primary connection is
+//bound, returning false when cancelled or timed out
+func (t *Tunnel) WaitPrimary(ctx context.Context) bool {
+	return t.getPrimary(ctx) != nil
+}
+
+func (t *Tunnel) waitSSH(ctx context.Context, activating *waitGroup, get func() *poolConn) ssh.Conn {
 	//cancelled already?
 	if isDone(ctx) {
 		return nil
 	}
-	t.activeConnMut.RLock()
-	c := t.activeConn
-	t.activeConnMut.RUnlock()
+	t.connsMut.RLock()
+	c := get()
+	t.connsMut.RUnlock()
 	//connected already?
 	if c != nil {
 		return c
@@ -138,23 +387,21 @@ func (t *Tunnel) getSSH(ctx context.Context) ssh.Conn {
 		return nil
 	case <-time.After(settings.EnvDuration("SSH_WAIT", 35*time.Second)):
 		return nil //a bit longer than ssh timeout
-	case <-t.activatingConnWait():
-		t.activeConnMut.RLock()
-		c := t.activeConn
-		t.activeConnMut.RUnlock()
+	case <-activatingWait(activating):
+		t.connsMut.RLock()
+		c := get()
+		t.connsMut.RUnlock()
+		if c == nil {
+			return nil
+		}
 		return c
 	}
 }
 
-//IsInbound returns true if this is a client tunnel (inbound)
-func (t *Tunnel) IsInbound() bool {
-	return t.Inbound
-}
-
-func (t *Tunnel) activatingConnWait() <-chan struct{} {
+func activatingWait(w *waitGroup) <-chan struct{} {
 	ch := make(chan struct{})
 	go func() {
-		t.activatingConn.Wait()
+		w.Wait()
 		close(ch)
 	}()
 	return ch
@@ -171,19 +418,20 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	}
 	proxies := make([]*Proxy, len(remotes))
 	for i, remote := range remotes {
-		p, err := NewProxy(t.Logger, t, t.proxyCount, remote)
+		p, err := t.newProxy(remote)
 		if err != nil {
 			return err
 		}
 		proxies[i] = p
-		t.proxyCount++
 	}
 	//TODO: handle tunnel close
 	eg, ctx := errgroup.WithContext(ctx)
 	for _, proxy := range proxies {
 		p := proxy
+		pctx, done := t.trackProxy(ctx, p)
 		eg.Go(func() error {
-			return p.Run(ctx)
+			defer done()
+			return p.Run(pctx)
 		})
 	}
 	t.Debugf("Bound proxies")
@@ -194,12 +442,12 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 
 func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 	//ping forever with randomized intervals
-	//Default: ±30% jitter to make traffic patterns less predictable
+	//jitter makes traffic patterns less predictable
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	jitterPercent := 0.30 // 30% jitter (highest level)
-	
+	jitterPercent := t.Obfuscation.KeepAliveJitter
+
 	for {
-		// Calculate jitter: ±30% of KeepAlive duration
+		// Calculate jitter: ±jitterPercent of KeepAlive duration
 		baseDuration := float64(t.Config.KeepAlive)
 		jitterRange := baseDuration * jitterPercent
 		// Random value between -jitterRange and +jitterRange
@@ -213,6 +461,7 @@ func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 		}
 		
 		time.Sleep(actualDuration)
+		t0 := time.Now()
 		_, b, err := sshConn.SendRequest("ping", true, nil)
 		if err != nil {
 			break
@@ -221,49 +470,8 @@ func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 			t.Debugf("strange ping response")
 			break
 		}
-		t.updateLastActivity()
+		t.lastPing.set(t0, time.Since(t0))
 	}
 	//close ssh connection on abnormal ping
 	sshConn.Close()
 }
-
-// connectionPoolHealthCheck periodically checks connection pool health
-func (t *Tunnel) connectionPoolHealthCheck() {
-	if t.healthCheck == nil {
-		return
-	}
-	
-	for range t.healthCheck.C {
-		t.connPoolMut.Lock()
-		// Clean up dead connections
-		validConns := make([]ssh.Conn, 0)
-		for _, conn := range t.connPool {
-			if conn != nil && !isConnDead(conn) {
-				validConns = append(validConns, conn)
-			} else {
-				t.Debugf("Removed dead connection from pool")
-			}
-		}
-		t.connPool = validConns
-		t.connPoolMut.Unlock()
-		
-		// Log pool status
-		if t.Logger.Debug {
-			t.Debugf("Connection pool size: %d", len(t.connPool))
-		}
-	}
-}
-
-// updateLastActivity updates the last activity timestamp
-func (t *Tunnel) updateLastActivity() {
-	t.connPoolMut.Lock()
-	t.lastActivity = time.Now()
-	t.connPoolMut.Unlock()
-}
-
-// isConnDead checks if an SSH connection is dead
-func isConnDead(conn ssh.Conn) bool {
-	// Try to send a small ping to check connection health
-	_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
-	return err != nil
-}
@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"sync/atomic"
+
+	"github.com/jpillora/chisel/share/traffic"
+	"github.com/jpillora/sizestr"
+	"golang.org/x/crypto/ssh"
+)
+
+//coverRequest is the SSH request type carrying cover
+//traffic, it wants no reply and is discarded by the peer
+const coverRequest = "pad"
+
+//startCover sends cover traffic over the given connection
+//while the tunnel has no open channels
+func (t *Tunnel) startCover(c ssh.Conn) *coverSimulator {
+	m := t.metrics()
+	sim := t.Cover.NewSimulator()
+	sim.SetIdle(func() bool {
+		return atomic.LoadInt32(&t.channels) == 0
+	})
+	sim.SetSender(func(payload []byte) error {
+		_, _, err := c.SendRequest(coverRequest, false, payload)
+		if err == nil {
+			m.cover(len(payload))
+		}
+		return err
+	})
+	sim.Start()
+	t.Debugf("Cover traffic started (pattern %s, intensity %d%%)", t.Cover.Pattern, t.Cover.Intensity)
+	return &coverSimulator{Simulator: sim, t: t}
+}
+
+type coverSimulator struct {
+	*traffic.Simulator
+	t *Tunnel
+}
+
+//Stop the simulator and report its overhead
+func (c *coverSimulator) Stop() {
+	c.Simulator.Stop()
+	packets, bytes := c.Stats()
+	c.t.Debugf("Cover traffic stopped (sent %d packets, %s)", packets, sizestr.ToString(bytes))
+}
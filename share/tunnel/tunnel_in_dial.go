@@ -0,0 +1,311 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jpillora/chisel/share/cio"
+	"golang.org/x/crypto/ssh"
+)
+
+//DialContext opens a connection to the given address on the
+//other end of the tunnel, without binding any local listener.
+//The connection is carried by a chisel SSH channel, so it is
+//subject to the same checks as the channels of a Proxy.
+func (t *Tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !t.Inbound {
+		return nil, errors.New("inbound connections blocked")
+	}
+	proto := strings.TrimRight(network, "46")
+	if proto != "tcp" && proto != "udp" {
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	sshConn := t.getSSH(ctx)
+	if sshConn == nil {
+		return nil, errors.New("no remote connection")
+	}
+	dst := addr
+	if proto == "udp" {
+		dst += "/udp"
+	}
+	//dialed destinations are not configured remotes
+	m := t.metrics()
+	if err := t.admit(); err != nil {
+		m.fail(labelDirect)
+		return nil, err
+	}
+	ch, reqs, err := sshConn.OpenChannel("chisel", []byte(dst))
+	if err != nil {
+		m.fail(labelDirect)
+		return nil, fmt.Errorf("ssh-chan error: %s", err)
+	}
+	closed := m.open(labelDirect)
+	go ssh.DiscardRequests(reqs)
+	c := &dialConn{
+		ch:      m.meter(labelDirect, cio.LimitRWC(t.account(ch), t.Limit)),
+		local:   chanAddr{proto, sshConn.LocalAddr().String()},
+		remote:  chanAddr{proto, addr},
+		closed:  closed,
+		reads:   make(chan dialRead),
+		done:    make(chan struct{}),
+	}
+	if proto == "udp" {
+		c.udp = &udpChannel{
+			r: gob.NewDecoder(c.ch),
+			w: gob.NewEncoder(c.ch),
+			c: c.ch,
+		}
+		//the exit node keys its udp sockets by source
+		c.src = fmt.Sprintf("dial#%p", c)
+	}
+	go c.recv()
+	t.Debugf("Dialed %s/%s", addr, proto)
+	return c, nil
+}
+
+//chanAddr is the net.Addr of a channel endpoint
+type chanAddr struct {
+	network, addr string
+}
+
+func (a chanAddr) Network() string {
+	return a.network
+}
+
+func (a chanAddr) String() string {
+	return a.addr
+}
+
+//dialConn is a net.Conn over a chisel SSH channel
+type dialConn struct {
+	ch            io.ReadWriteCloser
+	local, remote chanAddr
+	closed        func()
+	closeOnce     sync.Once
+	//reads are received in the background,
+	//so that their deadline may interrupt them
+	reads     chan dialRead
+	rbuf      []byte
+	rerr      error
+	done      chan struct{}
+	rdeadline deadline
+	wdeadline deadline
+	//udp only
+	udp      *udpChannel
+	src      string
+	rmu, wmu sync.Mutex
+}
+
+//dialRead is the data or error of a channel read
+type dialRead struct {
+	b   []byte
+	err error
+}
+
+//recv reads the channel until it closes, each
+//udp read being a single datagram
+func (c *dialConn) recv() {
+	for {
+		r := dialRead{}
+		if c.udp == nil {
+			buf := make([]byte, 32*1024)
+			n, err := c.ch.Read(buf)
+			r = dialRead{buf[:n], err}
+		} else {
+			p := udpPacket{}
+			r.err = c.udp.decode(&p)
+			r.b = p.Payload
+		}
+		select {
+		case c.reads <- r:
+		case <-c.done:
+			return
+		}
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+func (c *dialConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.rbuf) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		if c.rdeadline.exceeded() {
+			return 0, os.ErrDeadlineExceeded
+		}
+		select {
+		case r := <-c.reads:
+			c.rbuf, c.rerr = r.b, r.err
+			if c.udp != nil && len(r.b) == 0 && r.err == nil {
+				//empty datagram
+				return 0, nil
+			}
+		case <-c.rdeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-c.done:
+			return 0, net.ErrClosed
+		}
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	if c.udp != nil {
+		//each read returns at most one datagram
+		c.rbuf = nil
+	}
+	return n, nil
+}
+
+func (c *dialConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.wdeadline.exceeded() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	//writes block while the window of the channel is
+	//full, so passing their deadline closes the channel
+	if c.wdeadline.isSet() {
+		written := make(chan struct{})
+		defer close(written)
+		go func() {
+			select {
+			case <-c.wdeadline.wait():
+				c.ch.Close()
+			case <-written:
+			}
+		}()
+	}
+	n, err := c.write(b)
+	if err != nil && c.wdeadline.exceeded() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *dialConn) write(b []byte) (int, error) {
+	if c.udp == nil {
+		return c.ch.Write(b)
+	}
+	if err := c.udp.encode(c.src, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *dialConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.closed()
+	})
+	return c.ch.Close()
+}
+
+func (c *dialConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *dialConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *dialConn) SetDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	c.wdeadline.set(t)
+	return nil
+}
+
+//SetReadDeadline interrupts reads once passed,
+//the conn may be read again after extending it
+func (c *dialConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	return nil
+}
+
+//SetWriteDeadline closes the conn when a write
+//is blocked once it has passed
+func (c *dialConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline.set(t)
+	return nil
+}
+
+//deadline of a dialConn, its wait channel
+//closes once the deadline has passed
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel //the timer fired, wait for it to close
+	}
+	d.timer = nil
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	return d.cancel
+}
+
+func (d *deadline) exceeded() bool {
+	return isClosed(d.wait())
+}
+
+func (d *deadline) isSet() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.timer != nil || (d.cancel != nil && isClosed(d.cancel))
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
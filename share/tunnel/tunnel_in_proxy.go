@@ -6,7 +6,9 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/jpillora/chisel/share/audit"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/sizestr"
@@ -16,7 +18,12 @@ import (
 //sshTunnel exposes a subset of Tunnel to subtypes
 type sshTunnel interface {
 	getSSH(ctx context.Context) ssh.Conn
-	IsInbound() bool
+	metrics() tunnelMetrics
+	pipe(src, dst io.ReadWriteCloser, ls ...*cio.Limiter) (int64, int64)
+	limiter() *cio.Limiter
+	admit() error
+	account(ch io.ReadWriteCloser) io.ReadWriteCloser
+	audit(e audit.Event)
 }
 
 //Proxy is the inbound portion of a Tunnel
@@ -26,6 +33,7 @@ type Proxy struct {
 	id     int
 	count  int
 	remote *settings.Remote
+	limit  *cio.Limiter
 	dialer net.Dialer
 	tcp    *net.TCPListener
 	udp    *udpListener
@@ -39,21 +47,31 @@ type Proxy struct {
 
 // ConnectionStats tracks proxy connection statistics
 type ConnectionStats struct {
-	TotalConnections int64
-	ActiveConnections int32
-	FailedConnections int64
-	BytesSent        int64
-	BytesReceived    int64
+	TotalConnections int64 `json:"totalConnections"`
+	ActiveConnections int32 `json:"activeConnections"`
+	FailedConnections int64 `json:"failedConnections"`
+	BytesSent        int64 `json:"bytesSent"`
+	BytesReceived    int64 `json:"bytesReceived"`
+}
+
+//Add adds the given stats to these stats
+func (s *ConnectionStats) Add(o ConnectionStats) {
+	s.TotalConnections += o.TotalConnections
+	s.ActiveConnections += o.ActiveConnections
+	s.FailedConnections += o.FailedConnections
+	s.BytesSent += o.BytesSent
+	s.BytesReceived += o.BytesReceived
 }
 
 //NewProxy creates a Proxy
 func NewProxy(logger *cio.Logger, sshTun sshTunnel, index int, remote *settings.Remote) (*Proxy, error) {
 	id := index + 1
 	p := &Proxy{
-		Logger:    logger.Fork("proxy#%s", remote.String()),
+		Logger:    logger.ForkID("proxy", remote.String()),
 		sshTun:    sshTun,
 		id:        id,
 		remote:    remote,
+		limit:     cio.NewLimiter(remote.Rate),
 		maxConns:  100, // Maximum concurrent connections
 		connPool:  make(chan struct{}, 100),
 		connStats: &ConnectionStats{},
@@ -61,13 +79,30 @@ func NewProxy(logger *cio.Logger, sshTun sshTunnel, index int, remote *settings.
 	return p, p.listen()
 }
 
-func (p *Proxy) listen() error {
-	if p.remote.Reverse && !p.sshTun.IsInbound() {
-		// For reverse proxies, we don't listen locally on the server side
-		// The client will listen locally and forward connections through SSH
-		p.Infof("Reverse proxy configured")
-		return nil
+//Stats returns a snapshot of the connection statistics
+func (p *Proxy) Stats() ConnectionStats {
+	return ConnectionStats{
+		TotalConnections:  atomic.LoadInt64(&p.connStats.TotalConnections),
+		ActiveConnections: atomic.LoadInt32(&p.connStats.ActiveConnections),
+		FailedConnections: atomic.LoadInt64(&p.connStats.FailedConnections),
+		BytesSent:         atomic.LoadInt64(&p.connStats.BytesSent),
+		BytesReceived:     atomic.LoadInt64(&p.connStats.BytesReceived),
+	}
+}
+
+//Addr returns the address the proxy listens
+//on, or "stdio" for stdio remotes
+func (p *Proxy) Addr() string {
+	switch {
+	case p.tcp != nil:
+		return p.tcp.Addr().String()
+	case p.udp != nil:
+		return p.udp.inbound.LocalAddr().String() + "/udp"
 	}
+	return "stdio"
+}
+
+func (p *Proxy) listen() error {
 	if p.remote.Stdio {
 		//TODO check if pipes active?
 	} else if p.remote.LocalProto == "tcp" {
@@ -82,7 +117,7 @@ func (p *Proxy) listen() error {
 		p.Infof("Listening")
 		p.tcp = l
 	} else if p.remote.LocalProto == "udp" {
-		l, err := listenUDP(p.Logger, p.sshTun, p.remote)
+		l, err := listenUDP(p.Logger, p.sshTun, p.remote, p.limit)
 		if err != nil {
 			return err
 		}
@@ -121,13 +156,6 @@ func (p *Proxy) runStdio(ctx context.Context) error {
 }
 
 func (p *Proxy) runTCP(ctx context.Context) error {
-	if p.remote.Reverse && !p.sshTun.IsInbound() {
-		// For reverse proxies, we don't run TCP listener on the server side
-		// The client will handle the local listening and forwarding
-		<-ctx.Done()
-		return nil
-	}
-	
 	done := make(chan struct{})
 	//implements missing net.ListenContext
 	go func() {
@@ -151,7 +179,7 @@ func (p *Proxy) runTCP(ctx context.Context) error {
 				//listener closed
 				err = nil
 			default:
-				p.Infof("Accept error: %s", err)
+				p.Warnf("Accept error: %s", err)
 			}
 			close(done)
 			return err
@@ -197,7 +225,7 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 	cid := p.count
 	p.mu.Unlock()
 
-	l := p.Fork("conn#%d", cid)
+	l := p.ForkID("conn", cid)
 	l.Debugf("Open")
 	
 	// Update connection statistics
@@ -205,22 +233,49 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 	atomic.AddInt32(&p.connStats.ActiveConnections, 1)
 	defer atomic.AddInt32(&p.connStats.ActiveConnections, -1)
 	
+	m := p.sshTun.metrics()
+	remote := p.remote.String()
+	dst := p.remote.Remote()
+	source := ""
+	if c, ok := src.(net.Conn); ok {
+		source = c.RemoteAddr().String()
+	}
 	sshConn := p.sshTun.getSSH(ctx)
 	if sshConn == nil {
 		l.Debugf("No remote connection")
 		atomic.AddInt64(&p.connStats.FailedConnections, 1)
+		m.fail(remote)
+		return
+	}
+	if err := p.sshTun.admit(); err != nil {
+		l.Warnf("Denied connection (%s)", err)
+		atomic.AddInt64(&p.connStats.FailedConnections, 1)
+		m.fail(remote)
+		p.sshTun.audit(audit.Event{Type: audit.ChannelDenied, Source: source, Destination: dst, Reason: err.Error()})
 		return
 	}
 	//ssh request for tcp connection for this proxy's remote
-	dst, reqs, err := sshConn.OpenChannel("chisel", []byte(p.remote.Remote()))
+	ch, reqs, err := sshConn.OpenChannel("chisel", []byte(dst))
 	if err != nil {
-		l.Infof("Stream error: %s", err)
+		l.Warnf("Stream error: %s", err)
 		atomic.AddInt64(&p.connStats.FailedConnections, 1)
+		m.fail(remote)
+		p.sshTun.audit(audit.Event{Type: audit.ChannelDenied, Source: source, Destination: dst, Reason: err.Error()})
 		return
 	}
 	go ssh.DiscardRequests(reqs)
+	defer m.open(remote)()
+	opened := time.Now()
 	//then pipe
-	s, r := cio.Pipe(src, dst)
+	s, r := p.sshTun.pipe(src, m.meter(remote, p.sshTun.account(ch)), p.limit, p.sshTun.limiter())
+	p.sshTun.audit(audit.Event{
+		Type:        audit.Channel,
+		Source:      source,
+		Destination: dst,
+		Sent:        s,
+		Received:    r,
+		DurationMs:  time.Since(opened).Milliseconds(),
+	})
 	
 	// Update traffic statistics
 	atomic.AddInt64(&p.connStats.BytesSent, s)
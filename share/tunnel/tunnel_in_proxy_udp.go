@@ -11,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/jpillora/chisel/share/audit"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/sizestr"
@@ -31,7 +32,7 @@ import (
 // we must store these mappings (1111-6345, etc) in memory for a length
 // of time, so that when the exit node receives a response on 6345, it
 // knows to return it to 1111.
-func listenUDP(l *cio.Logger, sshTun sshTunnel, remote *settings.Remote) (*udpListener, error) {
+func listenUDP(l *cio.Logger, sshTun sshTunnel, remote *settings.Remote, limit *cio.Limiter) (*udpListener, error) {
 	a, err := net.ResolveUDPAddr("udp", remote.Local())
 	if err != nil {
 		return nil, l.Errorf("resolve: %s", err)
@@ -45,6 +46,7 @@ func listenUDP(l *cio.Logger, sshTun sshTunnel, remote *settings.Remote) (*udpLi
 		Logger:      l,
 		sshTun:      sshTun,
 		remote:      remote,
+		limit:       limit,
 		inbound:     conn,
 		maxMTU:      settings.EnvInt("UDP_MAX_SIZE", 9012),
 		connMap:     &sync.Map{},
@@ -59,6 +61,7 @@ type udpListener struct {
 	*cio.Logger
 	sshTun      sshTunnel
 	remote      *settings.Remote
+	limit       *cio.Limiter
 	inbound     *net.UDPConn
 	outboundMut sync.Mutex
 	outbound    *udpChannel
@@ -116,7 +119,7 @@ func (u *udpListener) runInbound(ctx context.Context) error {
 			return u.Errorf("read error: %w", err)
 		}
 
-		u.Debugf("Received UDP packet from %s, size: %d bytes", addr.String(), n)
+		u.Tracef("Received UDP packet from %s, size: %d bytes", addr.String(), n)
 
 		// Use packet queue for better performance
 		packet := &udpPacket{
@@ -128,12 +131,13 @@ func (u *udpListener) runInbound(ctx context.Context) error {
 		select {
 		case u.packetQueue <- packet:
 			// Packet queued successfully
-			u.Debugf("Queued UDP packet from %s", addr.String())
+			u.Tracef("Queued UDP packet from %s", addr.String())
 		case <-ctx.Done():
 			return nil
 		default:
 			// Queue full, drop packet but log warning
 			atomic.AddInt64(&u.stats.FailedPackets, 1)
+			u.sshTun.metrics().drop(u.remote.String())
 			u.Debugf("UDP packet queue full, dropped packet from %s", addr.String())
 		}
 
@@ -141,6 +145,7 @@ func (u *udpListener) runInbound(ctx context.Context) error {
 		atomic.AddInt64(&u.sent, int64(n))
 		atomic.AddInt64(&u.stats.PacketsSent, 1)
 		atomic.AddInt64(&u.stats.BytesSent, int64(n))
+		u.sshTun.metrics().bytes(u.remote.String(), int64(n), 0)
 	}
 	return nil
 }
@@ -176,6 +181,7 @@ func (u *udpListener) runOutbound(ctx context.Context) error {
 		atomic.AddInt64(&u.recv, int64(n))
 		atomic.AddInt64(&u.stats.PacketsReceived, 1)
 		atomic.AddInt64(&u.stats.BytesReceived, int64(n))
+		u.sshTun.metrics().bytes(u.remote.String(), 0, int64(n))
 	}
 	return nil
 }
@@ -195,15 +201,24 @@ func (u *udpListener) getUDPChan(ctx context.Context) (*udpChannel, error) {
 	//ssh request for udp packets for this proxy's remote,
 	//just "udp" since the remote address is sent with each packet
 	dstAddr := u.remote.Remote() + "/udp"
-	rwc, reqs, err := sshConn.OpenChannel("chisel", []byte(dstAddr))
+	if err := u.sshTun.admit(); err != nil {
+		u.sshTun.metrics().fail(u.remote.String())
+		u.sshTun.audit(audit.Event{Type: audit.ChannelDenied, Destination: dstAddr, Reason: err.Error()})
+		return nil, err
+	}
+	ch, reqs, err := sshConn.OpenChannel("chisel", []byte(dstAddr))
 	if err != nil {
+		u.sshTun.metrics().fail(u.remote.String())
 		return nil, fmt.Errorf("ssh-chan error: %s", err)
 	}
 	go ssh.DiscardRequests(reqs)
+	counted := &countChannel{ReadWriteCloser: ch}
+	rwc := cio.LimitRWC(u.sshTun.account(counted), u.limit, u.sshTun.limiter())
+	closed := u.sshTun.metrics().open(u.remote.String())
 	//remove on disconnect
 	// Track active channels
 	atomic.AddInt32(&u.stats.ActiveChannels, 1)
-	go u.unsetUDPChan(sshConn)
+	go u.unsetUDPChan(sshConn, counted, dstAddr, closed)
 	//ready
 	o := &udpChannel{
 		r: gob.NewDecoder(rwc),
@@ -211,7 +226,7 @@ func (u *udpListener) getUDPChan(ctx context.Context) (*udpChannel, error) {
 		c: rwc,
 	}
 	u.outbound = o
-	u.Debugf("aquired channel")
+	u.Tracef("aquired channel")
 	return o, nil
 }
 
@@ -263,11 +278,14 @@ func (u *udpListener) processPacketQueue(ctx context.Context) error {
 	return nil
 }
 
-func (u *udpListener) unsetUDPChan(sshConn ssh.Conn) {
+func (u *udpListener) unsetUDPChan(sshConn ssh.Conn, counted *countChannel, dst string, closed func()) {
+	opened := time.Now()
 	sshConn.Wait()
+	u.sshTun.audit(counted.closed(dst, opened, nil))
 	u.Debugf("lost channel")
 	u.outboundMut.Lock()
 	u.outbound = nil
 	u.outboundMut.Unlock()
 	atomic.AddInt32(&u.stats.ActiveChannels, -1)
+	closed()
 }
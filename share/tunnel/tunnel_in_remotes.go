@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jpillora/chisel/share/settings"
+)
+
+//AddRemote binds a proxy for the given remote while the Tunnel
+//is running, it runs until the context is cancelled or the
+//remote is removed with RemoveRemote
+func (t *Tunnel) AddRemote(ctx context.Context, remote *settings.Remote) error {
+	if !t.Inbound {
+		return errors.New("inbound connections blocked")
+	}
+	if t.HasRemote(remote) {
+		return fmt.Errorf("remote %s is already bound", remote)
+	}
+	p, err := t.newProxy(remote)
+	if err != nil {
+		return err
+	}
+	pctx, done := t.trackProxy(ctx, p)
+	go func() {
+		defer done()
+		if err := p.Run(pctx); err != nil {
+			p.Debugf("Stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+//RemoveRemote stops the proxy of the given remote,
+//connections already established are not affected
+func (t *Tunnel) RemoveRemote(remote *settings.Remote) error {
+	t.proxiesMut.Lock()
+	b, ok := t.proxies[remote.Encode()]
+	t.proxiesMut.Unlock()
+	if !ok {
+		return fmt.Errorf("remote %s is not bound", remote)
+	}
+	b.cancel()
+	return nil
+}
+
+//SetRemoteLimit changes the rate limit of a bound
+//remote to its Rate, including established connections
+func (t *Tunnel) SetRemoteLimit(remote *settings.Remote) error {
+	t.proxiesMut.Lock()
+	b, ok := t.proxies[remote.Encode()]
+	t.proxiesMut.Unlock()
+	if !ok {
+		return fmt.Errorf("remote %s is not bound", remote)
+	}
+	b.limit.SetRate(remote.Rate)
+	return nil
+}
+
+func (t *Tunnel) newProxy(remote *settings.Remote) (*Proxy, error) {
+	t.proxiesMut.Lock()
+	index := t.proxyCount
+	t.proxyCount++
+	t.proxiesMut.Unlock()
+	return NewProxy(t.Logger, t, index, remote)
+}
+
+//HasRemote returns whether a proxy of the given remote is bound
+func (t *Tunnel) HasRemote(remote *settings.Remote) bool {
+	t.proxiesMut.Lock()
+	_, ok := t.proxies[remote.Encode()]
+	t.proxiesMut.Unlock()
+	return ok
+}
+
+//boundProxy is a running proxy of the Tunnel
+type boundProxy struct {
+	*Proxy
+	cancel context.CancelFunc
+}
+
+//trackProxy registers the given proxy, so that it can be
+//removed, done must be called once it has stopped
+func (t *Tunnel) trackProxy(ctx context.Context, p *Proxy) (context.Context, func()) {
+	key := p.remote.Encode()
+	ctx, cancel := context.WithCancel(ctx)
+	t.proxiesMut.Lock()
+	t.proxies[key] = &boundProxy{Proxy: p, cancel: cancel}
+	t.proxiesMut.Unlock()
+	return ctx, func() {
+		cancel()
+		t.proxiesMut.Lock()
+		delete(t.proxies, key)
+		t.proxiesMut.Unlock()
+	}
+}
+
+//Request sends a request of the given type to the other end
+//of the Tunnel, and waits for its reply. A rejected request
+//returns the reply as an error.
+func (t *Tunnel) Request(ctx context.Context, name string, payload []byte) error {
+	sshConn := t.getPrimary(ctx)
+	if sshConn == nil {
+		return errors.New("no remote connection")
+	}
+	type reply struct {
+		ok  bool
+		b   []byte
+		err error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		ok, b, err := sshConn.SendRequest(name, true, payload)
+		replies <- reply{ok, b, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r := <-replies:
+		if r.err != nil {
+			return r.err
+		}
+		if !r.ok {
+			if len(r.b) == 0 {
+				return fmt.Errorf("%s request rejected", name)
+			}
+			return errors.New(string(r.b))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/jpillora/chisel/share/cnet"
+)
+
+//Listener is an in-process net.Listener for an outbound
+//address. Channels to this address are accepted by the
+//Listener instead of being dialed by the Tunnel.
+type Listener struct {
+	addr  chanAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+//NewListener creates a Listener for the given host:port
+func NewListener(addr string) *Listener {
+	return &Listener{
+		addr:  chanAddr{"tcp", addr},
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+//Accept waits for and returns the next tunnelled connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+//Close stops the Listener, channels to its address
+//are rejected, already accepted connections stay open
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+//Addr returns the outbound address of this Listener
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+//serve hands over the stream to Accept, and blocks
+//until the accepted connection is closed
+func (l *Listener) serve(src io.ReadWriteCloser) error {
+	c := &listenerConn{
+		Conn:   cnet.NewRWCConn(src),
+		closed: make(chan struct{}),
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		return errors.New("listener closed")
+	}
+	<-c.closed
+	return nil
+}
+
+type listenerConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *listenerConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return err
+}
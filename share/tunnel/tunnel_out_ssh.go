@@ -1,13 +1,20 @@
 package tunnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/armon/go-socks5"
+	"github.com/jpillora/chisel/share/audit"
 	"github.com/jpillora/chisel/share/cio"
 	"github.com/jpillora/chisel/share/cnet"
+	"github.com/jpillora/chisel/share/egress"
 	"github.com/jpillora/chisel/share/settings"
 	"github.com/jpillora/sizestr"
 	"golang.org/x/crypto/ssh"
@@ -18,12 +25,36 @@ func (t *Tunnel) handleSSHRequests(reqs <-chan *ssh.Request) {
 		switch r.Type {
 		case "ping":
 			r.Reply(true, []byte("pong"))
+		case coverRequest:
+			//discard cover traffic
+		case "remotes":
+			t.handleRemotesRequest(r)
 		default:
 			t.Debugf("Unknown request: %s", r.Type)
+			if r.WantReply {
+				r.Reply(false, nil)
+			}
 		}
 	}
 }
 
+func (t *Tunnel) handleRemotesRequest(r *ssh.Request) {
+	if t.Remotes == nil {
+		r.Reply(false, []byte("remote updates are not supported"))
+		return
+	}
+	u, err := settings.DecodeRemotesUpdate(r.Payload)
+	if err == nil {
+		err = t.Remotes(u)
+	}
+	if err != nil {
+		t.Debugf("Remotes update failed: %s", err)
+		r.Reply(false, []byte(err.Error()))
+		return
+	}
+	r.Reply(true, nil)
+}
+
 func (t *Tunnel) handleSSHChannels(chans <-chan ssh.NewChannel) {
 	for ch := range chans {
 		go t.handleSSHChannel(ch)
@@ -31,36 +62,83 @@ func (t *Tunnel) handleSSHChannels(chans <-chan ssh.NewChannel) {
 }
 
 func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
-	if !t.Config.Outbound {
+	remote := string(ch.ExtraData())
+	m := t.metrics()
+	reject := func(code ssh.RejectionReason, reason string) {
+		ch.Reject(code, reason)
+		m.fail(labelDenied)
+		t.audit(audit.Event{Type: audit.ChannelDenied, Destination: remote, Reason: reason})
+	}
+	outbound, socksEnabled := t.outbound()
+	if !outbound {
 		t.Debugf("Denied outbound connection")
-		ch.Reject(ssh.Prohibited, "Denied outbound connection")
+		reject(ssh.Prohibited, "Denied outbound connection")
 		return
 	}
-	remote := string(ch.ExtraData())
 	//extract protocol
 	hostPort, proto := settings.L4Proto(remote)
 	udp := proto == "udp"
 	socks := hostPort == "socks"
-	if socks && t.socksServer == nil {
+	if socks && !socksEnabled {
 		t.Debugf("Denied socks request, please enable socks")
-		ch.Reject(ssh.Prohibited, "SOCKS5 is not enabled")
+		reject(ssh.Prohibited, "SOCKS5 is not enabled")
+		return
+	}
+	//channels may be dialed directly, not only by the
+	//validated remotes, so confirm the user has access
+	//(socks destinations are checked by socksRules)
+	if t.User != nil && !socks && !t.User.HasAccess(hostPort) {
+		t.Warnf("Denied access to '%s' for user %s", hostPort, t.User.Name)
+		reject(ssh.Prohibited, "access to '"+hostPort+"' denied")
+		return
+	}
+	if t.User != nil && socks && t.User.DenySocks {
+		t.Warnf("Denied socks request for user %s", t.User.Name)
+		reject(ssh.Prohibited, "SOCKS5 access denied")
+		return
+	}
+	if err := t.admit(); err != nil {
+		t.Warnf("Denied channel (%s)", err)
+		reject(ssh.Prohibited, err.Error())
+		return
+	}
+	if !t.acquireChannel() {
+		t.Warnf("Denied channel, user %s has %d open", t.User.Name, t.User.MaxChannels)
+		reject(ssh.ResourceShortage, "too many channels")
 		return
 	}
+	defer t.releaseChannel()
 	sshChan, reqs, err := ch.Accept()
 	if err != nil {
 		t.Debugf("Failed to accept stream: %s", err)
 		return
 	}
-	stream := io.ReadWriteCloser(sshChan)
+	counted := &countChannel{ReadWriteCloser: sshChan}
+	stream := cio.LimitRWC(t.account(counted), t.Limit)
 	//cnet.MeterRWC(t.Logger.Fork("sshchan"), sshChan)
 	defer stream.Close()
 	go ssh.DiscardRequests(reqs)
-	l := t.Logger.Fork("conn#%d", t.connStats.New())
+	cid := t.connStats.New()
+	l := t.Logger.ForkID("conn", cid).With("remote", remote)
 	//ready to handle
+	defer m.open(t.remoteLabel(remote))()
 	t.connStats.Open()
 	l.Debugf("Open %s", t.connStats.String())
+	opened := time.Now()
+	var listener *Listener
+	if t.Config.Listener != nil && !socks && !udp {
+		listener = t.Config.Listener(hostPort)
+	}
+	dst, denied := remote, ""
 	if socks {
-		err = t.handleSocks(stream)
+		req := &socksRequest{}
+		err = t.newSocksServer(req).ServeConn(cnet.NewRWCConn(stream))
+		if req.dest != "" {
+			dst = req.dest
+		}
+		denied = req.denied
+	} else if listener != nil {
+		err = listener.serve(stream)
 	} else if udp {
 		err = t.handleUDP(l, stream, hostPort)
 	} else {
@@ -72,18 +150,74 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 		errmsg = fmt.Sprintf(" (error %s)", err)
 	}
 	l.Debugf("Close %s%s", t.connStats.String(), errmsg)
+	e := counted.closed(dst, opened, err)
+	if denied != "" {
+		e.Type, e.Reason = audit.ChannelDenied, denied
+	}
+	t.audit(e)
+}
+
+//socksRequest records the request of a SOCKS channel
+type socksRequest struct {
+	dest, denied string
+}
+
+//socksRules allows the SOCKS destinations
+//to which the user of the Tunnel has access
+type socksRules struct {
+	t   *Tunnel
+	req *socksRequest
+}
+
+func (r socksRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	user := r.t.User
+	addr := socksAddr(req.DestAddr)
+	r.req.dest = addr
+	if user != nil && !user.HasAccess(addr) {
+		r.t.Warnf("Denied socks access to '%s' for user %s", addr, user.Name)
+		r.t.metrics().fail(labelDenied)
+		r.req.denied = "access to '" + addr + "' denied"
+		return ctx, false
+	}
+	return ctx, true
+}
+
+//socksResolver defers resolving SOCKS destinations
+//to the dialer, leaving their names to be checked
+type socksResolver struct{}
+
+func (socksResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	return ctx, nil, nil
 }
 
-func (t *Tunnel) handleSocks(src io.ReadWriteCloser) error {
-	return t.socksServer.ServeConn(cnet.NewRWCConn(src))
+//socksAddr returns the host:port of a SOCKS destination,
+//preferring the requested name to its resolved IP
+func socksAddr(a *socks5.AddrSpec) string {
+	host := a.FQDN
+	if host == "" {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+//dial connects to an outbound destination,
+//subject to the egress policy of the Tunnel
+func (t *Tunnel) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := t.Egress.Dial(ctx, network, addr)
+	if errors.Is(err, egress.ErrDenied) {
+		t.Warnf("Denied %s egress to '%s'", network, addr)
+	}
+	return c, err
 }
 
 func (t *Tunnel) handleTCP(l *cio.Logger, src io.ReadWriteCloser, hostPort string) error {
-	dst, err := net.Dial("tcp", hostPort)
+	m, label := t.metrics(), t.remoteLabel(hostPort)
+	dst, err := t.dial(context.Background(), "tcp", hostPort)
 	if err != nil {
+		m.fail(label)
 		return err
 	}
-	s, r := cio.Pipe(src, dst)
+	s, r := t.pipe(m.meter(label, src), dst)
 	l.Debugf("sent %s received %s", sizestr.ToString(s), sizestr.ToString(r))
 	return nil
 }
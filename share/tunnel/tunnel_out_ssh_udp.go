@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"context"
 	"encoding/gob"
 	"io"
 	"net"
@@ -15,12 +16,14 @@ import (
 func (t *Tunnel) handleUDP(l *cio.Logger, rwc io.ReadWriteCloser, hostPort string) error {
 	conns := &udpConns{
 		Logger: l,
+		dialer: t.dial,
 		m:      map[string]*udpConn{},
 	}
 	defer conns.closeAll()
 	h := &udpHandler{
 		Logger:   l,
 		hostPort: hostPort,
+		label:    t.remoteLabel(hostPort + "/udp"),
 		udpChannel: &udpChannel{
 			r: gob.NewDecoder(rwc),
 			w: gob.NewEncoder(rwc),
@@ -28,6 +31,7 @@ func (t *Tunnel) handleUDP(l *cio.Logger, rwc io.ReadWriteCloser, hostPort strin
 		},
 		udpConns: conns,
 		maxMTU:   settings.EnvInt("UDP_MAX_SIZE", 9012),
+		metrics:  t.metrics(),
 	}
 	h.Debugf("UDP max size: %d bytes", h.maxMTU)
 	for {
@@ -41,9 +45,11 @@ func (t *Tunnel) handleUDP(l *cio.Logger, rwc io.ReadWriteCloser, hostPort strin
 type udpHandler struct {
 	*cio.Logger
 	hostPort string
+	label    string
 	*udpChannel
 	*udpConns
-	maxMTU int
+	maxMTU  int
+	metrics tunnelMetrics
 }
 
 func (h *udpHandler) handleWrite(p *udpPacket) error {
@@ -70,10 +76,11 @@ func (h *udpHandler) handleWrite(p *udpPacket) error {
 			h.Debugf("exceeded max udp connections (%d)", maxConns)
 		}
 	}
-	_, err = conn.Write(p.Payload)
+	n, err := conn.Write(p.Payload)
 	if err != nil {
 		return err
 	}
+	h.metrics.bytes(h.label, 0, int64(n))
 	return nil
 }
 
@@ -100,13 +107,15 @@ func (h *udpHandler) handleRead(p *udpPacket, conn *udpConn) {
 			h.Debugf("encode error: %s", err)
 			return
 		}
+		h.metrics.bytes(h.label, int64(n), 0)
 	}
 }
 
 type udpConns struct {
 	*cio.Logger
 	sync.Mutex
-	m map[string]*udpConn
+	dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+	m      map[string]*udpConn
 }
 
 func (cs *udpConns) dial(id, addr string) (*udpConn, bool, error) {
@@ -114,7 +123,7 @@ func (cs *udpConns) dial(id, addr string) (*udpConn, bool, error) {
 	defer cs.Unlock()
 	conn, ok := cs.m[id]
 	if !ok {
-		c, err := net.Dial("udp", addr)
+		c, err := cs.dialer(context.Background(), "udp", addr)
 		if err != nil {
 			return nil, false, err
 		}
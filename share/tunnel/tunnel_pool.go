@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//poolConn is an SSH connection of the Tunnel,
+//counting the channels opened over it
+type poolConn struct {
+	ssh.Conn
+	primary  bool
+	channels int32
+}
+
+//OpenChannel opens a channel, which is counted until closed
+func (c *poolConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	atomic.AddInt32(&c.channels, 1)
+	ch, reqs, err := c.Conn.OpenChannel(name, data)
+	if err != nil {
+		atomic.AddInt32(&c.channels, -1)
+		return nil, nil, err
+	}
+	return &poolChannel{Channel: ch, c: c}, reqs, nil
+}
+
+type poolChannel struct {
+	ssh.Channel
+	c    *poolConn
+	once sync.Once
+}
+
+func (p *poolChannel) Close() error {
+	p.once.Do(func() {
+		atomic.AddInt32(&p.c.channels, -1)
+	})
+	return p.Channel.Close()
+}
+
+//primary returns the primary connection, if bound,
+//the caller holds connsMut
+func (t *Tunnel) primary() *poolConn {
+	for _, c := range t.conns {
+		if c.primary {
+			return c
+		}
+	}
+	return nil
+}
+
+//leastLoaded returns the connection with the fewest
+//open channels, preferring those bound first, the
+//caller holds connsMut
+func (t *Tunnel) leastLoaded() *poolConn {
+	var least *poolConn
+	for _, c := range t.conns {
+		if least == nil || atomic.LoadInt32(&c.channels) < atomic.LoadInt32(&least.channels) {
+			least = c
+		}
+	}
+	return least
+}
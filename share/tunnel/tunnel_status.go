@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ProxyStatus describes a running proxy
+type ProxyStatus struct {
+	Remote   string `json:"remote"`
+	Listener string `json:"listener"`
+	//Rate is the rate limit in bytes per second
+	Rate int64 `json:"rate,omitempty"`
+	ConnectionStats
+}
+
+//Proxies returns the status of the running proxies,
+//in the order in which they were bound
+func (t *Tunnel) Proxies() []ProxyStatus {
+	t.proxiesMut.Lock()
+	bound := make([]*boundProxy, 0, len(t.proxies))
+	for _, b := range t.proxies {
+		bound = append(bound, b)
+	}
+	t.proxiesMut.Unlock()
+	sort.Slice(bound, func(i, j int) bool {
+		return bound[i].id < bound[j].id
+	})
+	statuses := make([]ProxyStatus, len(bound))
+	for i, b := range bound {
+		statuses[i] = ProxyStatus{
+			Remote:          b.remote.String(),
+			Listener:        b.Addr(),
+			Rate:            b.limit.Rate(),
+			ConnectionStats: b.Stats(),
+		}
+	}
+	return statuses
+}
+
+//TotalStats sums the connection statistics of the given proxies
+func TotalStats(proxies []ProxyStatus) ConnectionStats {
+	total := ConnectionStats{}
+	for _, p := range proxies {
+		total.Add(p.ConnectionStats)
+	}
+	return total
+}
+
+//Connected returns whether the Tunnel has an active SSH connection
+func (t *Tunnel) Connected() bool {
+	return t.Connections() > 0
+}
+
+//Connections returns the number of active SSH connections
+func (t *Tunnel) Connections() int {
+	t.connsMut.RLock()
+	defer t.connsMut.RUnlock()
+	return len(t.conns)
+}
+
+//Channels returns the number of open channels
+func (t *Tunnel) Channels() int32 {
+	return atomic.LoadInt32(&t.channels)
+}
+
+//Outbound returns the number of open and total
+//outbound connections made for the other end
+func (t *Tunnel) Outbound() (open, total int32) {
+	return t.connStats.Load()
+}
+
+//LastPing returns the round trip time of the last
+//keepalive ping, and when it was sent. The time is
+//zero when no ping has completed yet.
+func (t *Tunnel) LastPing() (time.Duration, time.Time) {
+	return t.lastPing.get()
+}
+
+type lastPing struct {
+	mut     sync.Mutex
+	at      time.Time
+	latency time.Duration
+}
+
+func (l *lastPing) set(at time.Time, latency time.Duration) {
+	l.mut.Lock()
+	l.at, l.latency = at, latency
+	l.mut.Unlock()
+}
+
+func (l *lastPing) get() (time.Duration, time.Time) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.latency, l.at
+}
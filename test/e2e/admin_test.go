@@ -0,0 +1,110 @@
+package e2e_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestAdminSessions(t *testing.T) {
+	tmpPort := availablePort()
+	adminAddr := "127.0.0.1:" + availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:    unblockLocal,
+			Auth:      "foo:bar",
+			Admin:     adminAddr,
+			AdminAuth: "admin:secret",
+		},
+		client: &chclient.Config{
+			Auth:          "foo:bar",
+			Remotes:       []string{tmpPort + ":$FILEPORT"},
+			MaxRetryCount: -1, //keep running once kicked
+		},
+		fileServer: true,
+	}
+	_, _, teardown := tl.setup(t)
+	defer teardown()
+	//make some traffic
+	waitPort(t, tmpPort)
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	admin := func(method, path, auth string) *http.Response {
+		req, _ := http.NewRequest(method, "http://"+adminAddr+path, nil)
+		if auth != "" {
+			req.SetBasicAuth("admin", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	//credentials required
+	if resp := admin("GET", "/sessions", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+	//list sessions
+	resp := admin("GET", "/sessions", "secret")
+	var sessions []chserver.SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sessions))
+	}
+	sess := sessions[0]
+	if sess.User != "foo" || len(sess.Remotes) != 1 || sess.BytesSent == 0 || sess.BytesReceived == 0 {
+		t.Fatalf("unexpected session %+v", sess)
+	}
+	//kick it
+	path := fmt.Sprintf("/sessions/%d", sess.ID)
+	if resp := admin("DELETE", path, "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if resp := admin("GET", path, "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminListenFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//the admin address is taken
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port, rawPort := availablePort(), availablePort()
+	server, err := chserver.NewServer(&chserver.Config{
+		Admin:     busy.Addr().String(),
+		AdminAuth: "admin:secret",
+		Listen:    []string{"tcp://127.0.0.1:" + rawPort},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	if err := server.StartContext(ctx, "127.0.0.1", port); err == nil {
+		t.Fatal("expected the busy admin address to fail the start")
+	}
+	//nothing is left listening
+	for _, p := range []string{port, rawPort} {
+		l, err := net.Listen("tcp", "127.0.0.1:"+p)
+		if err != nil {
+			t.Fatalf("expected port %s to be closed: %s", p, err)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,139 @@
+package e2e_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/audit"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:   unblockLocal,
+			Users:    policyUsers(t, `{"allow": ["^127\\.0\\.0\\.1:"]}`),
+			AuditLog: path,
+		},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort + ":127.0.0.1:$FILEPORT"},
+			Auth:    "foo:bar",
+		},
+		fileServer: true,
+	}
+	server, client, teardown := tl.setup(t)
+	defer teardown()
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.DialContext(ctx, "tcp", "10.0.0.1:22"); err == nil {
+		t.Fatal("expected the channel to be denied")
+	}
+	//remotes added and removed while connected
+	added := availablePort() + ":" + tl.fileAddr()
+	if err := client.AddRemote(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveRemote(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+	//a session with the wrong password
+	other, err := chclient.NewClient(&chclient.Config{
+		Fingerprint:   server.GetFingerprint(),
+		Server:        "http://" + server.Status().Listen,
+		Auth:          "foo:baz",
+		MaxRetryCount: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other.Wait()
+	//channels are recorded as they close
+	client.Close()
+	events := map[string]audit.Event{}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(events) < 6 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		events = readAudit(t, path)
+	}
+	if e := events[audit.SessionStart]; e.User != "foo" || e.Source == "" || len(e.Remotes) != 1 {
+		t.Fatalf("unexpected session start %+v", e)
+	}
+	if e := events[audit.Channel]; e.Destination != tl.fileAddr() || e.Sent == 0 || e.Received == 0 || e.Session == 0 {
+		t.Fatalf("unexpected channel %+v", e)
+	}
+	if e := events[audit.ChannelDenied]; e.Destination != "10.0.0.1:22" || e.Reason == "" {
+		t.Fatalf("unexpected denied channel %+v", e)
+	}
+	if e := events[audit.SessionDenied]; e.Reason == "" {
+		t.Fatalf("unexpected denied session %+v", e)
+	}
+	if e := events[audit.RemotesUpdate]; e.User != "foo" || len(e.Removed) != 1 || len(e.Remotes) != 0 || e.Reason != "" {
+		t.Fatalf("unexpected remotes update %+v", e)
+	}
+	if e := events[audit.SessionEnd]; e.Sent == 0 || e.DurationMs == 0 {
+		t.Fatalf("unexpected session end %+v", e)
+	}
+}
+
+func TestAuditConfigTimeout(t *testing.T) {
+	t.Setenv("CHISEL_CONFIG_TIMEOUT", "100ms")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	rawPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Auth:     "foo:bar",
+			Listen:   []string{"tcp://127.0.0.1:" + rawPort},
+			AuditLog: path,
+		},
+		client: &chclient.Config{Auth: "foo:bar"},
+	}
+	_, _, teardown := tl.setup(t)
+	defer teardown()
+	//a client which never sends its config
+	c, err := ssh.Dial("tcp", "127.0.0.1:"+rawPort, &ssh.ClientConfig{
+		User:            "foo",
+		Auth:            []ssh.AuthMethod{ssh.Password("bar")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Wait()
+	if e := readAudit(t, path)[audit.SessionDenied]; e.User != "foo" || e.Reason != "timeout waiting for configuration" {
+		t.Fatalf("unexpected denied session %+v", e)
+	}
+}
+
+// readAudit returns the last event of each type in the audit log
+func readAudit(t *testing.T, path string) map[string]audit.Event {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events := map[string]audit.Event{}
+	for s := bufio.NewScanner(f); s.Scan(); {
+		e := audit.Event{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("invalid audit line %q: %s", s.Text(), err)
+		}
+		events[e.Type] = e
+	}
+	return events
+}
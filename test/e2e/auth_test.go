@@ -1,10 +1,18 @@
 package e2e_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 
 	chclient "github.com/jpillora/chisel/client"
 	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
 //TODO tests for:
@@ -18,6 +26,7 @@ func TestAuth(t *testing.T) {
 	//setup server, client, fileserver
 	teardown := simpleSetup(t,
 		&chserver.Config{
+			Egress:  unblockLocal,
 			KeySeed: "foobar",
 			Auth:    "../bench/userfile",
 		},
@@ -46,3 +55,92 @@ func TestAuth(t *testing.T) {
 		t.Fatalf("expected exclamation mark added again")
 	}
 }
+
+func TestAuthHashed(t *testing.T) {
+	hash, err := settings.HashPassword("bar", bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		auth string
+		ok   bool
+	}{
+		{"foo:bar", true},
+		{"foo:" + hash, false},
+		{"foo:baz", false},
+	} {
+		tmpPort := availablePort()
+		teardown := simpleSetup(t,
+			&chserver.Config{
+				Egress: unblockLocal,
+				Auth:   "foo:" + hash,
+			},
+			&chclient.Config{
+				Remotes:       []string{tmpPort + ":$FILEPORT"},
+				Auth:          tc.auth,
+				MaxRetryCount: 0,
+			})
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		teardown()
+		if ok := err == nil && result == "foo!"; ok != tc.ok {
+			t.Fatalf("auth %s: expected ok=%v, got %q %v", tc.auth, tc.ok, result, err)
+		}
+	}
+}
+
+func TestAuthKey(t *testing.T) {
+	dir := t.TempDir()
+	//client key, in the openssh format
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, _ := ssh.NewPublicKey(pub)
+	authorized := `user="laptop",addr="^127\.0\.0\.1:\d+$" ` + string(ssh.MarshalAuthorizedKey(sshPub))
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(authorizedKeys, []byte(authorized), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		key    string
+		remote string
+		ok     bool
+	}{
+		{"authorized", keyFile, "127.0.0.1:$FILEPORT", true},
+		{"denied remote", keyFile, "localhost:$FILEPORT", false},
+		{"no key", "", "127.0.0.1:$FILEPORT", false},
+	} {
+		tmpPort := availablePort()
+		tl := testLayout{
+			server: &chserver.Config{
+				Egress:         unblockLocal,
+				AuthorizedKeys: authorizedKeys,
+			},
+			client: &chclient.Config{
+				Remotes:       []string{tmpPort + ":" + tc.remote},
+				ClientKey:     tc.key,
+				MaxRetryCount: 0,
+			},
+			fileServer: true,
+		}
+		server, _, teardown := tl.setup(t)
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		sessions := server.Sessions()
+		teardown()
+		if ok := err == nil && result == "foo!"; ok != tc.ok {
+			t.Fatalf("%s: expected ok=%v, got %q %v", tc.name, tc.ok, result, err)
+		}
+		if tc.ok && (len(sessions) != 1 || sessions[0].User != "laptop") {
+			t.Fatalf("%s: expected session of laptop, got %+v", tc.name, sessions)
+		}
+	}
+}
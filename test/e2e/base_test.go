@@ -1,6 +1,10 @@
 package e2e_test
 
 import (
+	"context"
+	"io"
+	"net"
+	"net/http"
 	"testing"
 	"time"
 
@@ -12,7 +16,7 @@ func TestBase(t *testing.T) {
 	tmpPort := availablePort()
 	//setup server, client, fileserver
 	teardown := simpleSetup(t,
-		&chserver.Config{},
+		&chserver.Config{Egress: unblockLocal},
 		&chclient.Config{
 			Remotes: []string{tmpPort + ":$FILEPORT"},
 		})
@@ -38,8 +42,8 @@ func TestReverse(t *testing.T) {
 			Remotes: []string{"R:127.0.0.1:" + tmpPort + ":127.0.0.1:$FILEPORT"},
 		})
 	defer teardown()
-	// Wait a bit for connections to stabilize
-	time.Sleep(100 * time.Millisecond)
+	//wait for the server to bind the reverse remote
+	waitPort(t, tmpPort)
 	//test remote (this goes through the server and out the client)
 	result, err := post("http://localhost:"+tmpPort, "foo")
 	if err != nil {
@@ -49,3 +53,138 @@ func TestReverse(t *testing.T) {
 		t.Fatalf("expected exclamation mark added")
 	}
 }
+
+func TestReverseReleasedOnDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//file server (client-side service)
+	fileAddr := "127.0.0.1:" + availablePort()
+	fl, err := net.Listen("tcp", fileAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	go (&http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	})}).Serve(fl)
+	//server
+	server, err := chserver.NewServer(&chserver.Config{Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	serverPort := availablePort()
+	if err := server.StartContext(ctx, "127.0.0.1", serverPort); err != nil {
+		t.Fatal(err)
+	}
+	//client, with its own lifetime
+	tmpPort := availablePort()
+	client, err := chclient.NewClient(&chclient.Config{
+		Fingerprint: server.GetFingerprint(),
+		Server:      "http://127.0.0.1:" + serverPort,
+		Remotes:     []string{"R:127.0.0.1:" + tmpPort + ":" + fileAddr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Debug = debug
+	clientCtx, clientCancel := context.WithCancel(ctx)
+	if err := client.Start(clientCtx); err != nil {
+		t.Fatal(err)
+	}
+	waitPort(t, tmpPort)
+	//server-side listener reaches the client-side service
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//end the session, the server should release the port
+	clientCancel()
+	client.Wait()
+	time.Sleep(200 * time.Millisecond)
+	l, err := net.Listen("tcp", "127.0.0.1:"+tmpPort)
+	if err != nil {
+		t.Fatalf("expected reverse port to be released: %s", err)
+	}
+	l.Close()
+	//while the server itself keeps running
+	if _, err := post("http://127.0.0.1:"+serverPort+"/health", ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReverseDisabled(t *testing.T) {
+	tmpPort := availablePort()
+	//server without --reverse must not listen for the client
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes:       []string{"R:127.0.0.1:" + tmpPort + ":127.0.0.1:$FILEPORT"},
+			MaxRetryCount: 0,
+		})
+	defer teardown()
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err == nil {
+		t.Fatalf("expected reverse remote to be rejected")
+	}
+}
+
+func TestObfuscationOff(t *testing.T) {
+	tmpPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			Egress:      unblockLocal,
+			Obfuscation: "off",
+		},
+		&chclient.Config{
+			Remotes:     []string{tmpPort + ":$FILEPORT"},
+			Obfuscation: "low,chunk-size=512-1K",
+		})
+	defer teardown()
+	//test remote
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//invalid profiles are rejected
+	if _, err := chserver.NewServer(&chserver.Config{Obfuscation: "extreme"}); err == nil {
+		t.Fatal("expected invalid obfuscation error")
+	}
+}
+
+func TestObfuscationTransports(t *testing.T) {
+	for _, transport := range []string{chclient.TransportPoll, chclient.TransportH2} {
+		tmpPort := availablePort()
+		teardown := simpleSetup(t,
+			&chserver.Config{
+				Egress:      unblockLocal,
+				HTTP2:       true,
+				Obfuscation: "off",
+			},
+			&chclient.Config{
+				Remotes:     []string{tmpPort + ":$FILEPORT"},
+				Transport:   transport,
+				Obfuscation: "off,write-delay=200ms-200ms",
+			})
+		//writes of the client are delayed over every transport
+		t0 := time.Now()
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "foo!" {
+			t.Fatalf("%s: expected exclamation mark added", transport)
+		}
+		if d := time.Since(t0); d < 200*time.Millisecond {
+			t.Fatalf("%s: expected writes to be delayed, took %s", transport, d)
+		}
+		teardown()
+	}
+}
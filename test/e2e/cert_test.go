@@ -0,0 +1,210 @@
+package e2e_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// certKey generates an ed25519 key, written to dir/name in the openssh format
+func certKey(t *testing.T, dir, name string) (string, ssh.Signer) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, signer
+}
+
+// signCert signs a certificate of key, written to dir/name
+func signCert(t *testing.T, dir, name string, ca ssh.Signer, cert *ssh.Certificate) string {
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthCert(t *testing.T) {
+	dir := t.TempDir()
+	_, ca := certKey(t, dir, "ca")
+	authorized := `cert-authority,addr="^127\.0\.0\.1:\d+$" ` + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(authorizedKeys, []byte(authorized), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, key := certKey(t, dir, "id_ed25519")
+	now := time.Now()
+	valid := uint64(now.Add(time.Hour).Unix())
+	for _, tc := range []struct {
+		name   string
+		cert   *ssh.Certificate
+		remote string
+		ok     bool
+	}{
+		{"valid", &ssh.Certificate{
+			KeyId:           "valid",
+			ValidPrincipals: []string{"laptop"},
+			ValidBefore:     valid,
+		}, "127.0.0.1:$FILEPORT", true},
+		{"denied remote", &ssh.Certificate{
+			KeyId:           "denied",
+			ValidPrincipals: []string{"laptop"},
+			ValidBefore:     valid,
+		}, "localhost:$FILEPORT", false},
+		{"expired", &ssh.Certificate{
+			KeyId:           "expired",
+			ValidPrincipals: []string{"laptop"},
+			ValidAfter:      uint64(now.Add(-2 * time.Hour).Unix()),
+			ValidBefore:     uint64(now.Add(-time.Hour).Unix()),
+		}, "127.0.0.1:$FILEPORT", false},
+		{"no principals", &ssh.Certificate{
+			KeyId:       "anyone",
+			ValidBefore: valid,
+		}, "127.0.0.1:$FILEPORT", false},
+		//known users keep to the addresses of the authority
+		{"user", &ssh.Certificate{
+			KeyId:           "user",
+			ValidPrincipals: []string{"alice"},
+			ValidBefore:     valid,
+		}, "127.0.0.1:$FILEPORT", true},
+		{"user outside authority", &ssh.Certificate{
+			KeyId:           "outside",
+			ValidPrincipals: []string{"alice"},
+			ValidBefore:     valid,
+		}, "localhost:$FILEPORT", false},
+	} {
+		tc.cert.Key = key.PublicKey()
+		tc.cert.CertType = ssh.UserCert
+		certFile := signCert(t, dir, tc.cert.KeyId+"-cert.pub", ca, tc.cert)
+		tmpPort := availablePort()
+		tl := testLayout{
+			server: &chserver.Config{
+				Egress:         unblockLocal,
+				AuthorizedKeys: authorizedKeys,
+				Users: []*settings.User{{
+					Name:  "alice",
+					Pass:  "secret",
+					Addrs: []*regexp.Regexp{settings.UserAllowAll},
+				}},
+			},
+			client: &chclient.Config{
+				Remotes:       []string{tmpPort + ":" + tc.remote},
+				ClientKey:     keyFile,
+				ClientCert:    certFile,
+				MaxRetryCount: 0,
+			},
+			fileServer: true,
+		}
+		server, _, teardown := tl.setup(t)
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		sessions := server.Sessions()
+		teardown()
+		if ok := err == nil && result == "foo!"; ok != tc.ok {
+			t.Fatalf("%s: expected ok=%v, got %q %v", tc.name, tc.ok, result, err)
+		}
+		if tc.ok && (len(sessions) != 1 || sessions[0].User != tc.cert.ValidPrincipals[0]) {
+			t.Fatalf("%s: expected session of %s, got %+v", tc.name, tc.cert.ValidPrincipals[0], sessions)
+		}
+	}
+}
+
+func TestHostCert(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	_, ca := certKey(t, dir, "ca")
+	_, other := certKey(t, dir, "other")
+	keyFile, key := certKey(t, dir, "host")
+	certFile := signCert(t, dir, "host-cert.pub", ca, &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.HostCert,
+		KeyId:           "host",
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	})
+	server, err := chserver.NewServer(&chserver.Config{
+		KeyFile:  keyFile,
+		HostCert: certFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	serverPort := availablePort()
+	if err := server.StartContext(ctx, "127.0.0.1", serverPort); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		ca   ssh.PublicKey
+		host string
+		ok   bool
+	}{
+		{"trusted", ca.PublicKey(), "127.0.0.1", true},
+		{"untrusted", other.PublicKey(), "127.0.0.1", false},
+		{"wrong host", ca.PublicKey(), "localhost", false},
+	} {
+		caFile := filepath.Join(dir, "known_cas")
+		if err := os.WriteFile(caFile, ssh.MarshalAuthorizedKey(tc.ca), 0600); err != nil {
+			t.Fatal(err)
+		}
+		client, err := chclient.NewClient(&chclient.Config{
+			Server:        "http://" + tc.host + ":" + serverPort,
+			HostCA:        caFile,
+			MaxRetryCount: 0,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Debug = debug
+		cctx, ccancel := context.WithCancel(ctx)
+		if err := client.Start(cctx); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- client.Wait() }()
+		//rejected servers stop the client, otherwise it stays connected
+		select {
+		case <-done:
+			err = errors.New("client gave up")
+		case <-time.After(time.Second):
+			if n := len(server.Sessions()); n != 1 {
+				err = fmt.Errorf("expected 1 session, got %d", n)
+			}
+			ccancel()
+			<-done
+		}
+		ccancel()
+		if ok := err == nil; ok != tc.ok {
+			t.Fatalf("%s: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+	}
+}
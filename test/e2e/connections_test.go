@@ -0,0 +1,179 @@
+package e2e_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParallelConnections(t *testing.T) {
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{Egress: unblockLocal},
+		client: &chclient.Config{
+			Remotes:       []string{tmpPort + ":$FILEPORT"},
+			Connections:   3,
+			MaxRetryCount: -1, //keep running once kicked
+		},
+		fileServer: true,
+	}
+	server, client, teardown := tl.setup(t)
+	defer teardown()
+	//extra connections join the session of the primary one,
+	//which is polled, as connecting takes a while
+	waitConnections := func(prev int32) chserver.SessionInfo {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if s := server.Sessions(); len(s) == 1 && s[0].ID != prev && s[0].Connections == 3 && client.Status().SSHConnections == 3 {
+				return s[0]
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected a session of 3 connections, got %+v", server.Sessions())
+		return chserver.SessionInfo{}
+	}
+	sess := waitConnections(0)
+	//held connections are spread across the connections
+	conns := []net.Conn{}
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", "127.0.0.1:"+tmpPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		if result, err := roundTrip(c, "foo"); err != nil || result != "foo!" {
+			t.Fatalf("expected foo!, got %q %v", result, err)
+		}
+	}
+	if n := server.Sessions()[0].Channels; n != 3 {
+		t.Fatalf("expected the session to count 3 channels, got %d", n)
+	}
+	//closing the session closes its extra connections,
+	//and the client reconnects all of them
+	if err := server.CloseSession(sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitConnections(sess.ID)
+	http.DefaultClient.CloseIdleConnections()
+	if result, err := post("http://localhost:"+tmpPort, "bar"); err != nil || result != "bar!" {
+		t.Fatalf("expected bar!, got %q %v", result, err)
+	}
+}
+
+func TestExtraConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer files.Close()
+	rawPort := availablePort()
+	server, err := chserver.NewServer(&chserver.Config{
+		Users:  policyUsers(t, `{"allow": ["*"], "max-sessions": 1, "max-channels": 1}`),
+		Listen: []string{"tcp://127.0.0.1:" + rawPort},
+		Egress: unblockLocal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	if err := server.StartContext(ctx, "127.0.0.1", availablePort()); err != nil {
+		t.Fatal(err)
+	}
+	//connections of the raw listener, configured with c
+	connect := func(c settings.Config) (*ssh.Client, bool, string) {
+		conn, err := ssh.Dial("tcp", "127.0.0.1:"+rawPort, &ssh.ClientConfig{
+			User:            "foo",
+			Auth:            []ssh.AuthMethod{ssh.Password("bar")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, reply, err := conn.SendRequest("config", true, settings.EncodeConfig(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, ok, string(reply)
+	}
+	//extra connections need the token of a session
+	c, ok, reply := connect(settings.Config{Join: "guess"})
+	c.Close()
+	if ok || reply != "no session to join" {
+		t.Fatalf("expected the extra connection to be rejected, got %v %q", ok, reply)
+	}
+	//which is given to sessions with extra connections
+	primary, ok, token := connect(settings.Config{Connections: 2})
+	defer primary.Close()
+	if !ok || token == "" {
+		t.Fatalf("expected a join token, got %v %q", ok, token)
+	}
+	extra, ok, reply := connect(settings.Config{Join: token})
+	defer extra.Close()
+	if !ok {
+		t.Fatalf("expected the extra connection to join, got %q", reply)
+	}
+	//up to the connections of the session
+	c, ok, reply = connect(settings.Config{Join: token})
+	c.Close()
+	if ok || reply != "too many connections (max 2)" {
+		t.Fatalf("expected the extra connection to be rejected, got %v %q", ok, reply)
+	}
+	//and they still count as one session
+	c, ok, _ = connect(settings.Config{})
+	c.Close()
+	if ok {
+		t.Fatal("expected a second session to be rejected")
+	}
+	if s := server.Sessions(); len(s) != 1 || s[0].Connections != 2 {
+		t.Fatalf("expected a session of 2 connections, got %+v", s)
+	}
+	//channels are limited across the session
+	ch, _, err := primary.OpenChannel("chisel", []byte(files.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	if _, _, err := extra.OpenChannel("chisel", []byte(files.Listener.Addr().String())); err == nil {
+		t.Fatal("expected the channel of the extra connection to be denied")
+	}
+	//extra connections close with the session
+	primary.Close()
+	done := make(chan error, 1)
+	go func() { done <- extra.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the extra connection to close with the session")
+	}
+}
+
+// roundTrip posts the body over an open connection
+func roundTrip(c net.Conn, body string) (string, error) {
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(body))
+	if err := req.Write(c); err != nil {
+		return "", err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b := make([]byte, len(body)+1)
+	n, _ := resp.Body.Read(b)
+	return string(b[:n]), nil
+}
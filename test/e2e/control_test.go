@@ -0,0 +1,79 @@
+package e2e_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/control"
+)
+
+func TestControlStatus(t *testing.T) {
+	dir := t.TempDir()
+	tmpPort1 := availablePort()
+	tmpPort2 := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:  unblockLocal,
+			Reverse: true,
+			Control: filepath.Join(dir, "server.sock"),
+		},
+		client: &chclient.Config{
+			Remotes: []string{
+				tmpPort1 + ":$FILEPORT",
+				"R:127.0.0.1:" + tmpPort2 + ":127.0.0.1:$FILEPORT",
+			},
+			KeepAlive: 100 * time.Millisecond,
+			Control:   filepath.Join(dir, "client.sock"),
+		},
+		fileServer: true,
+	}
+	_, _, teardown := tl.setup(t)
+	defer teardown()
+	waitPort(t, tmpPort2)
+	for _, port := range []string{tmpPort1, tmpPort2} {
+		if _, err := post("http://localhost:"+port, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//wait for a keepalive ping
+	client := chclient.Status{}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.LatencyMs == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected ping latency")
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := control.Query(tl.client.Control, "status", &client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.Role != "client" || !client.Connected {
+		t.Fatalf("expected connected client, got %+v", client)
+	}
+	if len(client.Remotes) != 2 || len(client.Listeners) != 1 {
+		t.Fatalf("expected 2 remotes and 1 listener, got %+v", client)
+	}
+	if l := client.Listeners[0]; l.Listener != "[::]:"+tmpPort1 && l.Listener != "0.0.0.0:"+tmpPort1 {
+		t.Fatalf("unexpected listener %s", l.Listener)
+	}
+	if client.Connections.TotalConnections != 1 {
+		t.Fatalf("expected 1 connection, got %+v", client.Connections)
+	}
+	server := chserver.Status{}
+	if err := control.Query(tl.server.Control, "status", &server); err != nil {
+		t.Fatal(err)
+	}
+	if server.Role != "server" || len(server.Sessions) != 1 {
+		t.Fatalf("expected a single session, got %+v", server)
+	}
+	//including the connection of waitPort
+	if l := server.Sessions[0].Listeners; len(l) != 1 || l[0].Listener != "127.0.0.1:"+tmpPort2 || l[0].TotalConnections != 2 {
+		t.Fatalf("unexpected reverse listeners %+v", l)
+	}
+	if err := control.Query(tl.server.Control, "nope", &server); err == nil {
+		t.Fatal("expected unknown command error")
+	}
+}
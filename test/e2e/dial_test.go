@@ -0,0 +1,159 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestDialTCP(t *testing.T) {
+	tl := testLayout{
+		server:     &chserver.Config{Egress: unblockLocal},
+		client:     &chclient.Config{},
+		fileServer: true,
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	//plug the tunnel into an http client, no local listener
+	hc := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return client.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	resp, err := hc.Post("http://"+tl.fileAddr(), "text/plain", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
+
+func TestDialUDP(t *testing.T) {
+	//udp echo server
+	a, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	l, err := net.ListenUDP("udp", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		b := make([]byte, 128)
+		n, a, err := l.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		l.WriteTo(append(b[:n], b[:n]...), a)
+	}()
+	tl := testLayout{
+		server: &chserver.Config{Egress: unblockLocal},
+		client: &chclient.Config{},
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := client.DialContext(ctx, "udp", l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("bazz")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 128)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b[:n]); s != "bazzbazz" {
+		t.Fatalf("expected double bazz, got %s", s)
+	}
+}
+
+func TestDialDeadline(t *testing.T) {
+	//silent server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(c, b); err == nil {
+			c.Write(b)
+		}
+	}()
+	tl := testLayout{
+		server: &chserver.Config{Egress: unblockLocal},
+		client: &chclient.Config{},
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	conn, err := client.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	//reads time out while nothing arrives
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	b := make([]byte, 3)
+	_, err = conn.Read(b)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	//and succeed once the deadline is extended
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "foo" {
+		t.Fatalf("expected foo, got %q %v", b, err)
+	}
+}
+
+func TestDialDenied(t *testing.T) {
+	//user may only reach a port which isnt the file server
+	authfile := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(authfile, []byte(`{"foo:bar": ["^127.0.0.1:1$"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tl := testLayout{
+		server:     &chserver.Config{AuthFile: authfile},
+		client:     &chclient.Config{Auth: "foo:bar"},
+		fileServer: true,
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := client.DialContext(ctx, "tcp", tl.fileAddr())
+	if err == nil {
+		conn.Close()
+		t.Fatalf("expected dial to be denied")
+	}
+	if !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
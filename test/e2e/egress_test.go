@@ -0,0 +1,170 @@
+package e2e_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/egress"
+	"golang.org/x/net/proxy"
+)
+
+func TestEgress(t *testing.T) {
+	//udp echo server
+	a, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	ul, err := net.ListenUDP("udp", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	go func() {
+		b := make([]byte, 128)
+		for {
+			n, a, err := ul.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			ul.WriteTo(b[:n], a)
+		}
+	}()
+	for _, tc := range []struct {
+		name   string
+		policy egress.Config
+		ok     bool
+	}{
+		{"loopback denied by default", egress.Config{}, false},
+		{"loopback allowed", egress.Config{Allow: []string{"127.0.0.0/8"}}, true},
+		{"loopback unblocked", egress.Config{Unblock: []string{"127.0.0.0/8"}}, true},
+		{"port denied", egress.Config{Allow: []string{"127.0.0.0/8"}, Deny: []string{"*:1-65535"}}, false},
+		{"allowed by glob", egress.Config{Allow: []string{"*"}}, false},
+	} {
+		socksPort := availablePort()
+		tl := testLayout{
+			server: &chserver.Config{
+				Socks5: true,
+				Egress: tc.policy,
+			},
+			client: &chclient.Config{
+				Remotes: []string{socksPort + ":socks"},
+			},
+			fileServer: true,
+		}
+		_, client, teardown := tl.setup(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		//tcp
+		_, err := dialEcho(ctx, client.DialContext, "tcp", tl.fileAddr())
+		if ok := err == nil; ok != tc.ok {
+			t.Fatalf("%s: tcp: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+		//udp
+		reply, err := dialEcho(ctx, client.DialContext, "udp", ul.LocalAddr().String())
+		if ok := err == nil && reply == "ping"; ok != tc.ok {
+			t.Fatalf("%s: udp: expected ok=%v, got %q %v", tc.name, tc.ok, reply, err)
+		}
+		//socks
+		dialer, _ := proxy.SOCKS5("tcp", "127.0.0.1:"+socksPort, nil, proxy.Direct)
+		c, err := dialer.Dial("tcp", tl.fileAddr())
+		if err == nil {
+			c.Close()
+		}
+		if ok := err == nil; ok != tc.ok {
+			t.Fatalf("%s: socks: expected ok=%v, got %v", tc.name, tc.ok, err)
+		}
+		cancel()
+		teardown()
+	}
+}
+
+func TestEgressUnblock(t *testing.T) {
+	//another host, at a non-loopback address of this one
+	var other net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil && n.IP.IsGlobalUnicast() {
+			other = n.IP
+			break
+		}
+	}
+	if other == nil {
+		t.Skip("no non-loopback address")
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(other.String(), "0"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+	for _, tc := range []struct {
+		name   string
+		policy egress.Config
+		other  bool
+	}{
+		{"unblock", egress.Config{Unblock: []string{"127.0.0.1"}}, true},
+		{"allow", egress.Config{Allow: []string{"127.0.0.1"}}, false},
+	} {
+		tl := testLayout{
+			server:     &chserver.Config{Egress: tc.policy},
+			client:     &chclient.Config{},
+			fileServer: true,
+		}
+		_, client, teardown := tl.setup(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := dialEcho(ctx, client.DialContext, "tcp", tl.fileAddr()); err != nil {
+			t.Fatalf("%s: expected loopback to be allowed, got %v", tc.name, err)
+		}
+		//exempting loopback leaves other hosts allowed,
+		//while allowing it denies them
+		_, err := dialEcho(ctx, client.DialContext, "tcp", l.Addr().String())
+		if ok := err == nil; ok != tc.other {
+			t.Fatalf("%s: expected ok=%v for %s, got %v", tc.name, tc.other, l.Addr(), err)
+		}
+		cancel()
+		teardown()
+	}
+}
+
+// dialEcho dials through the tunnel and writes a ping, returning
+// the reply of udp echo servers, tcp connections are only opened
+func dialEcho(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, addr string) (string, error) {
+	c, err := dial(ctx, network, addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if network == "tcp" {
+		//denied channels are closed once accepted
+		if _, err := c.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+			return "", err
+		}
+		b := make([]byte, 1)
+		_, err := c.Read(b)
+		return "", err
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		return "", err
+	}
+	reply := make(chan string, 1)
+	go func() {
+		b := make([]byte, 128)
+		n, _ := c.Read(b)
+		reply <- string(b[:n])
+	}()
+	select {
+	case r := <-reply:
+		return r, nil
+	case <-time.After(time.Second):
+		return "", context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,151 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	defer files.Close()
+	//servers of their own keys
+	start := func(seed string) (*chserver.Server, string) {
+		s, err := chserver.NewServer(&chserver.Config{
+			KeySeed:     seed,
+			Obfuscation: "off",
+			Egress:      unblockLocal,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Debug = debug
+		port := availablePort()
+		if err := s.StartContext(ctx, "127.0.0.1", port); err != nil {
+			t.Fatal(err)
+		}
+		return s, "http://127.0.0.1:" + port
+	}
+	primary, primaryURL := start("primary")
+	backup, backupURL := start("backup")
+	tmpPort := availablePort()
+	client, err := chclient.NewClient(&chclient.Config{
+		//nothing listens on the first server
+		Server: "http://127.0.0.1:" + availablePort(),
+		Servers: []chclient.ServerConfig{
+			{URL: primaryURL, Fingerprint: primary.GetFingerprint()},
+			{URL: backupURL, Fingerprint: backup.GetFingerprint()},
+		},
+		Remotes:          []string{tmpPort + ":" + files.Listener.Addr().String()},
+		MaxRetryInterval: time.Second,
+		Obfuscation:      "off",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Debug = debug
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//the client is polled until connected to the server
+	waitServer := func(url string) {
+		ws := strings.Replace(url, "http", "ws", 1)
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if st := client.Status(); st.Connected && st.Server == ws {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("expected to connect to %s, got %+v", url, client.Status().Servers)
+	}
+	expectPost := func() {
+		http.DefaultClient.CloseIdleConnections()
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "foo!" {
+			t.Fatalf("expected exclamation mark added")
+		}
+	}
+	waitServer(primaryURL)
+	expectPost()
+	//the backup takes over once the primary dies
+	primary.Close()
+	for _, s := range primary.Sessions() {
+		primary.CloseSession(s.ID)
+	}
+	waitServer(backupURL)
+	expectPost()
+}
+
+func TestFailoverUnresponsive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//a server which accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	backup, err := chserver.NewServer(&chserver.Config{Obfuscation: "off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup.Debug = debug
+	port := availablePort()
+	if err := backup.StartContext(ctx, "127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	client, err := chclient.NewClient(&chclient.Config{
+		Server: "http://" + l.Addr().String(),
+		Servers: []chclient.ServerConfig{
+			{URL: "http://127.0.0.1:" + port, Fingerprint: backup.GetFingerprint()},
+		},
+		Remotes:        []string{availablePort() + ":127.0.0.1:1"},
+		ConnectTimeout: 500 * time.Millisecond,
+		Obfuscation:    "off",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Debug = debug
+	t0 := time.Now()
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//the unresponsive server is given up after the timeout
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.Status().Connected && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !client.Status().Connected {
+		t.Fatal("expected to fail over to the backup")
+	}
+	if d := time.Since(t0); d > 3*time.Second {
+		t.Fatalf("expected to fail over within the timeout, took %s", d)
+	}
+}
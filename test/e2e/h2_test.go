@@ -0,0 +1,79 @@
+package e2e_test
+
+import (
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestH2(t *testing.T) {
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConfig.Close()
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress: unblockLocal,
+			TLS:    *tlsConfig.serverTLS,
+			HTTP2:  true,
+		},
+		client: &chclient.Config{
+			Remotes:     []string{tmpPort + ":$FILEPORT"},
+			TLS:         *tlsConfig.clientTLS,
+			Transport:   chclient.TransportH2,
+			Connections: 2,
+		},
+		fileServer: true,
+	}
+	server, _, teardown := tl.setup(t)
+	defer teardown()
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//both connections are streams of one tcp connection,
+	//and the extra one joins the session of the first
+	deadline := time.Now().Add(5 * time.Second)
+	connections := func() int {
+		if s := server.Sessions(); len(s) == 1 {
+			return s[0].Connections
+		}
+		return 0
+	}
+	for connections() != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := connections(); n != 2 {
+		t.Fatalf("expected a session of 2 connections, got %+v", server.Sessions())
+	}
+}
+
+func TestH2C(t *testing.T) {
+	tmpPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			Egress: unblockLocal,
+			HTTP2:  true,
+		},
+		&chclient.Config{
+			Remotes:   []string{tmpPort + ":$FILEPORT"},
+			Transport: chclient.TransportH2,
+		})
+	defer teardown()
+	//test remote
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
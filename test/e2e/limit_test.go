@@ -0,0 +1,92 @@
+package e2e_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+// timePost posts 50KB, which is echoed back, returning how long it took
+func timePost(t *testing.T, port string) time.Duration {
+	body := strings.Repeat("x", 50000)
+	t0 := time.Now()
+	result, err := post("http://localhost:"+port, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != body+"!" {
+		t.Fatalf("expected body to be echoed")
+	}
+	return time.Since(t0)
+}
+
+func TestRemoteLimit(t *testing.T) {
+	ctx := context.Background()
+	tmpPort := availablePort()
+	revPort := availablePort()
+	tl := testLayout{
+		//without obfuscation delays
+		server: &chserver.Config{Obfuscation: "off", Reverse: true, Egress: unblockLocal},
+		client: &chclient.Config{
+			Remotes:     []string{tmpPort + ":$FILEPORT@50K", "R:127.0.0.1:" + revPort + ":127.0.0.1:$FILEPORT@50K"},
+			Obfuscation: "off",
+		},
+		fileServer: true,
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	//100KB in both directions, with a burst of 50KB
+	if d := timePost(t, tmpPort); d < 700*time.Millisecond {
+		t.Fatalf("expected the remote to be limited, took %s", d)
+	}
+	if err := client.SetRemoteLimit(ctx, tmpPort+":"+tl.filePort, 0); err != nil {
+		t.Fatal(err)
+	}
+	if d := timePost(t, tmpPort); d > 500*time.Millisecond {
+		t.Fatalf("expected the remote to be unlimited, took %s", d)
+	}
+	//reverse remotes are limited by the server
+	waitPort(t, revPort)
+	if d := timePost(t, revPort); d < 700*time.Millisecond {
+		t.Fatalf("expected the reverse remote to be limited, took %s", d)
+	}
+	if err := client.SetRemoteLimit(ctx, "R:127.0.0.1:"+revPort+":"+tl.fileAddr(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if d := timePost(t, revPort); d > 500*time.Millisecond {
+		t.Fatalf("expected the reverse remote to be unlimited, took %s", d)
+	}
+	if err := client.SetRemoteLimit(ctx, "1:2", 0); err == nil {
+		t.Fatal("expected unknown remote to fail")
+	}
+}
+
+func TestUserBandwidth(t *testing.T) {
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:      unblockLocal,
+			Users:       policyUsers(t, `{"allow": ["*"], "bandwidth": "50K"}`),
+			Obfuscation: "off",
+		},
+		client: &chclient.Config{
+			Remotes:     []string{tmpPort + ":$FILEPORT"},
+			Auth:        "foo:bar",
+			Obfuscation: "off",
+		},
+		fileServer: true,
+	}
+	server, _, teardown := tl.setup(t)
+	defer teardown()
+	if d := timePost(t, tmpPort); d < 700*time.Millisecond {
+		t.Fatalf("expected the user to be limited, took %s", d)
+	}
+	server.SetUserLimit("foo", 0)
+	if d := timePost(t, tmpPort); d > 500*time.Millisecond {
+		t.Fatalf("expected the user to be unlimited, took %s", d)
+	}
+}
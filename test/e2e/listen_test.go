@@ -0,0 +1,44 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestServerListen(t *testing.T) {
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort + ":control.internal:80"},
+		},
+	}
+	server, _, teardown := tl.setup(t)
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//serve http in-process, no port bound on the server
+	l, err := server.Listen(ctx, "", "control.internal:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '?'))
+	}))
+	if _, err := server.Listen(ctx, "", "control.internal:80"); err == nil {
+		t.Fatal("expected duplicate listen to fail")
+	}
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo?" {
+		t.Fatalf("expected question mark added, got %s", result)
+	}
+}
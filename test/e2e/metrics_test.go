@@ -0,0 +1,118 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestMetrics(t *testing.T) {
+	tmpPort := availablePort()
+	serverMetrics := "127.0.0.1:" + availablePort()
+	clientMetrics := "127.0.0.1:" + availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:  unblockLocal,
+			Auth:    "foo:bar",
+			Metrics: serverMetrics,
+		},
+		client: &chclient.Config{
+			Auth:    "foo:bar",
+			Remotes: []string{tmpPort + ":$FILEPORT"},
+			Metrics: clientMetrics,
+		},
+		fileServer: true,
+	}
+	_, c, teardown := tl.setup(t)
+	defer teardown()
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	//channels are labelled by the configured remote
+	remote := tmpPort + "=>" + tl.filePort
+	server := scrape(t, serverMetrics)
+	for _, line := range []string{
+		"chisel_sessions 1",
+		"chisel_auth_failures_total 0",
+		`chisel_channels_opened_total{remote="` + remote + `"} 1`,
+		`chisel_sent_bytes_total{user="foo",remote="` + remote + `"}`,
+	} {
+		if !strings.Contains(server, line) {
+			t.Fatalf("expected server metric %q in:\n%s", line, server)
+		}
+	}
+	client := scrape(t, clientMetrics)
+	for _, line := range []string{
+		"chisel_connected 1",
+		`chisel_channels_opened_total{remote="` + remote + `"} 1`,
+	} {
+		if !strings.Contains(client, line) {
+			t.Fatalf("expected client metric %q in:\n%s", line, client)
+		}
+	}
+	//the series of the remote end with it
+	if err := c.RemoveRemote(context.Background(), tmpPort+":"+tl.filePort); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(scrape(t, clientMetrics), remote) {
+		t.Fatalf("expected the client series of %s to be deleted", remote)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Contains(scrape(t, serverMetrics), remote) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the series of %s to be deleted", remote)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCoverTraffic(t *testing.T) {
+	tmpPort := availablePort()
+	clientMetrics := "127.0.0.1:" + availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress: unblockLocal,
+			Cover:  "ssh",
+		},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort + ":$FILEPORT"},
+			Metrics: clientMetrics,
+			Cover:   "ssh,intensity=100",
+		},
+		fileServer: true,
+	}
+	_, _, teardown := tl.setup(t)
+	defer teardown()
+	//wait for the client to send some cover traffic
+	deadline := time.Now().Add(10 * time.Second)
+	for strings.Contains(scrape(t, clientMetrics), "chisel_cover_packets_total 0\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected cover traffic")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	//cover traffic is discarded by both ends
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
+
+func scrape(t *testing.T, addr string) string {
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
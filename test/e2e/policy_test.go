@@ -0,0 +1,155 @@
+package e2e_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+)
+
+func policyUsers(t *testing.T, policy string) []*settings.User {
+	users, err := settings.ParseUsers([]byte(`{"foo:bar": ` + policy + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestUserPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy string
+		remote string
+		ok     bool
+	}{
+		{"allowed", `{"allow": ["^127\\.0\\.0\\.1:\\d+$"]}`, "", true},
+		{"denied", `{"allow": ["*"], "deny": ["^127\\.0\\.0\\.1:"]}`, "", false},
+		{"source", `{"allow": ["*"], "sources": ["127.0.0.0/8"]}`, "", true},
+		{"other source", `{"allow": ["*"], "sources": ["10.0.0.0/8"]}`, "", false},
+		{"expired", `{"allow": ["*"], "expires": "2000-01-01T00:00:00Z"}`, "", false},
+		{"socks", `{"allow": ["*"]}`, "socks", true},
+		{"socks denied", `{"allow": ["*"], "socks": false}`, "socks", false},
+		{"reverse denied", `{"allow": ["*"], "reverse": false}`, "R:" + availablePort() + ":127.0.0.1:80", false},
+	} {
+		tmpPort := availablePort()
+		remotes := []string{tmpPort + ":127.0.0.1:$FILEPORT"}
+		if tc.remote != "" {
+			remotes = append(remotes, tc.remote)
+		}
+		tl := testLayout{
+			server: &chserver.Config{
+				Egress:  unblockLocal,
+				Users:   policyUsers(t, tc.policy),
+				Socks5:  true,
+				Reverse: true,
+			},
+			client: &chclient.Config{
+				Remotes:       remotes,
+				Auth:          "foo:bar",
+				MaxRetryCount: 0,
+			},
+			fileServer: true,
+		}
+		_, _, teardown := tl.setup(t)
+		result, err := post("http://localhost:"+tmpPort, "foo")
+		teardown()
+		if ok := err == nil && result == "foo!"; ok != tc.ok {
+			t.Fatalf("%s: expected ok=%v, got %q %v", tc.name, tc.ok, result, err)
+		}
+	}
+}
+
+func TestUserMaxSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := chserver.NewServer(&chserver.Config{
+		Users: policyUsers(t, `{"allow": ["*"], "max-sessions": 1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	serverPort := availablePort()
+	if err := server.StartContext(ctx, "127.0.0.1", serverPort); err != nil {
+		t.Fatal(err)
+	}
+	connect := func(ctx context.Context) *chclient.Client {
+		client, err := chclient.NewClient(&chclient.Config{
+			Fingerprint:   server.GetFingerprint(),
+			Server:        "http://127.0.0.1:" + serverPort,
+			Auth:          "foo:bar",
+			MaxRetryCount: 0,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Debug = debug
+		if err := client.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	//sessions are polled, as connecting takes a while
+	waitSessions := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(server.Sessions()) != n && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if got := len(server.Sessions()); got != n {
+			t.Fatalf("expected %d sessions, got %d", n, got)
+		}
+	}
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	first := connect(firstCtx)
+	waitSessions(1)
+	//a second session of the user is rejected
+	connect(ctx).Wait()
+	waitSessions(1)
+	//until the first one disconnects
+	firstCancel()
+	first.Wait()
+	waitSessions(0)
+	connect(ctx)
+	waitSessions(1)
+}
+
+func TestUserMaxChannels(t *testing.T) {
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			Egress: unblockLocal,
+			Users:  policyUsers(t, `{"allow": ["*"], "max-channels": 1}`),
+		},
+		&chclient.Config{
+			Remotes: []string{tmpPort + ":$FILEPORT"},
+			Auth:    "foo:bar",
+		})
+	defer teardown()
+	//hold the only channel open
+	conn, err := net.Dial("tcp", "127.0.0.1:"+tmpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err == nil {
+		t.Fatal("expected the second channel to be rejected")
+	}
+	conn.Close()
+	//the channel is released once the server sees it close
+	deadline := time.Now().Add(5 * time.Second)
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		result, err = post("http://localhost:"+tmpPort, "foo")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
@@ -0,0 +1,95 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestPollTransport(t *testing.T) {
+	tmpPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{Egress: unblockLocal},
+		&chclient.Config{
+			Remotes:   []string{tmpPort + ":$FILEPORT"},
+			Transport: chclient.TransportPoll,
+		})
+	defer teardown()
+	//test remote, large enough to span many polls
+	body := strings.Repeat("foo", 1<<17)
+	result, err := post("http://localhost:"+tmpPort, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != body+"!" {
+		t.Fatalf("expected exclamation mark added to %d bytes, got %d", len(body), len(result))
+	}
+}
+
+func TestPollFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	defer files.Close()
+	server, err := chserver.NewServer(&chserver.Config{
+		Obfuscation: "off",
+		Egress:      unblockLocal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	port := availablePort()
+	if err := server.StartContext(ctx, "127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	//a proxy which strips websocket upgrades
+	u, _ := url.Parse("http://127.0.0.1:" + port)
+	rp := httputil.NewSingleHostReverseProxy(u)
+	director := rp.Director
+	rp.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Del("Upgrade")
+		r.Header.Del("Connection")
+	}
+	proxy := httptest.NewServer(rp)
+	defer proxy.Close()
+	tmpPort := availablePort()
+	client, err := chclient.NewClient(&chclient.Config{
+		Server:      proxy.URL,
+		Fingerprint: server.GetFingerprint(),
+		Remotes:     []string{tmpPort + ":" + files.Listener.Addr().String()},
+		Obfuscation: "off",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Debug = debug
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.Status().Connected && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
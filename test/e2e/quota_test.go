@@ -0,0 +1,53 @@
+package e2e_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/quota"
+)
+
+func TestUserQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	tmpPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:    unblockLocal,
+			Users:     policyUsers(t, `{"allow": ["*"], "quota-daily": "10K"}`),
+			QuotaFile: path,
+		},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort + ":$FILEPORT"},
+			Auth:    "foo:bar",
+		},
+		fileServer: true,
+	}
+	server, _, teardown := tl.setup(t)
+	defer teardown()
+	//the quota is checked as connections are opened
+	body := strings.Repeat("x", 20000)
+	if result, err := post("http://localhost:"+tmpPort, body); err != nil || result != body+"!" {
+		t.Fatalf("expected the first connection to work: %v", err)
+	}
+	if u := server.Usage("foo"); u.Daily < 40000 {
+		t.Fatalf("expected usage to be counted, got %+v", u)
+	}
+	//open a new connection, rather than reusing the first
+	http.DefaultClient.CloseIdleConnections()
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err == nil {
+		t.Fatal("expected the quota to be exceeded")
+	}
+	//usage is saved when the server stops
+	server.Close()
+	store, err := quota.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := store.Usage("foo"); u.Daily < 40000 {
+		t.Fatalf("expected usage to be saved, got %+v", u)
+	}
+}
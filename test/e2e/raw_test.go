@@ -0,0 +1,83 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+)
+
+func TestRawTransports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConfig.Close()
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	defer files.Close()
+	tcpPort, tlsPort := availablePort(), availablePort()
+	server, err := chserver.NewServer(&chserver.Config{
+		Auth:   "foo:bar",
+		TLS:    *tlsConfig.serverTLS,
+		Listen: []string{"tcp://127.0.0.1:" + tcpPort, "tls://127.0.0.1:" + tlsPort},
+		Egress: unblockLocal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	if err := server.StartContext(ctx, "127.0.0.1", availablePort()); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"tcp://127.0.0.1:" + tcpPort, "tls://localhost:" + tlsPort} {
+		t.Run(url, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			tmpPort := availablePort()
+			client, err := chclient.NewClient(&chclient.Config{
+				Server:      url,
+				Fingerprint: server.GetFingerprint(),
+				Auth:        "foo:bar",
+				TLS:         *tlsConfig.clientTLS,
+				Remotes:     []string{tmpPort + ":" + files.Listener.Addr().String()},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Debug = debug
+			if err := client.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !client.Status().Connected && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			result, err := post("http://localhost:"+tmpPort, "foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != "foo!" {
+				t.Fatalf("expected exclamation mark added")
+			}
+			//users authenticate as they do over http
+			if users := server.Sessions(); len(users) == 0 || users[len(users)-1].User != "foo" {
+				t.Fatalf("expected a session of foo, got %+v", users)
+			}
+		})
+	}
+	//raw servers have no default port
+	_, err = chclient.NewClient(&chclient.Config{Server: "tcp://127.0.0.1"})
+	if err == nil {
+		t.Fatal("expected the port of raw servers to be required")
+	}
+}
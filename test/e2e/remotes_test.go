@@ -0,0 +1,169 @@
+package e2e_test
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAddRemoveRemotes(t *testing.T) {
+	ctx := context.Background()
+	tmpPort1 := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress:  unblockLocal,
+			Reverse: true,
+		},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort1 + ":$FILEPORT"},
+		},
+		fileServer: true,
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	//picked once the file server has its port
+	tmpPort2 := availablePort()
+	tmpPort3 := availablePort()
+	//forward remote
+	if err := client.AddRemote(ctx, tmpPort2+":"+tl.filePort); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := post("http://localhost:"+tmpPort2, "foo"); err != nil || result != "foo!" {
+		t.Fatalf("expected added remote to work: %q %v", result, err)
+	}
+	//reverse remote
+	if err := client.AddRemote(ctx, "R:127.0.0.1:"+tmpPort3+":"+tl.fileAddr()); err != nil {
+		t.Fatal(err)
+	}
+	waitPort(t, tmpPort3)
+	if result, err := post("http://localhost:"+tmpPort3, "bar"); err != nil || result != "bar!" {
+		t.Fatalf("expected added reverse remote to work: %q %v", result, err)
+	}
+	if err := client.AddRemote(ctx, tmpPort2+":"+tl.filePort); err == nil {
+		t.Fatal("expected duplicate remote to fail")
+	}
+	//removed remotes release their ports
+	if err := client.RemoveRemote(ctx, tmpPort2+":"+tl.filePort); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveRemote(ctx, "R:127.0.0.1:"+tmpPort3+":"+tl.fileAddr()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, port := range []string{tmpPort2, tmpPort3} {
+		l, err := net.Listen("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			t.Fatalf("expected port %s to be released: %s", port, err)
+		}
+		l.Close()
+	}
+	//while the others keep working
+	if result, err := post("http://localhost:"+tmpPort1, "baz"); err != nil || result != "baz!" {
+		t.Fatalf("expected initial remote to work: %q %v", result, err)
+	}
+}
+
+func TestAddRemoteDenied(t *testing.T) {
+	ctx := context.Background()
+	tmpPort1 := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress: unblockLocal,
+			Users: []*settings.User{{
+				Name:  "foo",
+				Pass:  "bar",
+				Addrs: []*regexp.Regexp{regexp.MustCompile(`^127\.0\.0\.1:\d+$`)},
+			}},
+		},
+		client: &chclient.Config{
+			Auth:    "foo:bar",
+			Remotes: []string{tmpPort1 + ":127.0.0.1:$FILEPORT"},
+		},
+		fileServer: true,
+	}
+	_, client, teardown := tl.setup(t)
+	defer teardown()
+	tmpPort2 := availablePort()
+	if err := client.AddRemote(ctx, tmpPort2+":localhost:"+tl.filePort); err == nil {
+		t.Fatal("expected remote outside of the user's ACL to be denied")
+	}
+	if err := client.AddRemote(ctx, "R:"+tmpPort2+":"+tl.fileAddr()); err == nil {
+		t.Fatal("expected reverse remote to be denied")
+	}
+	//the denied port was never bound
+	l, err := net.Listen("tcp", "127.0.0.1:"+tmpPort2)
+	if err != nil {
+		t.Fatalf("expected port to be free: %s", err)
+	}
+	l.Close()
+	if result, err := post("http://localhost:"+tmpPort1, "foo"); err != nil || result != "foo!" {
+		t.Fatalf("expected initial remote to work: %q %v", result, err)
+	}
+}
+
+func TestUpdateRemotesRollback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rawPort := availablePort()
+	server, err := chserver.NewServer(&chserver.Config{
+		Auth:    "foo:bar",
+		Listen:  []string{"tcp://127.0.0.1:" + rawPort},
+		Reverse: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Debug = debug
+	if err := server.StartContext(ctx, "127.0.0.1", availablePort()); err != nil {
+		t.Fatal(err)
+	}
+	reverse := func(port string) *settings.Remote {
+		r, err := settings.DecodeRemote("R:127.0.0.1:" + port + ":127.0.0.1:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	tmpPort1, tmpPort2 := availablePort(), availablePort()
+	conn, err := ssh.Dial("tcp", "127.0.0.1:"+rawPort, &ssh.ClientConfig{
+		User:            "foo",
+		Auth:            []ssh.AuthMethod{ssh.Password("bar")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	config := settings.Config{Remotes: settings.Remotes{reverse(tmpPort1)}}
+	if ok, reply, err := conn.SendRequest("config", true, settings.EncodeConfig(config)); err != nil || !ok {
+		t.Fatalf("expected config to be accepted: %q %v", reply, err)
+	}
+	waitPort(t, tmpPort1)
+	//the second added remote fails to bind, as its
+	//port is only taken once the first is bound
+	update := settings.RemotesUpdate{
+		Add:    settings.Remotes{reverse(tmpPort2), reverse(tmpPort2)},
+		Remove: settings.Remotes{reverse(tmpPort1)},
+	}
+	if ok, _, err := conn.SendRequest("remotes", true, settings.EncodeRemotesUpdate(update)); err != nil || ok {
+		t.Fatalf("expected update to be rejected: %v", err)
+	}
+	//which leaves the remotes as they were
+	time.Sleep(100 * time.Millisecond)
+	l, err := net.Listen("tcp", "127.0.0.1:"+tmpPort2)
+	if err != nil {
+		t.Fatalf("expected port %s to be released: %s", tmpPort2, err)
+	}
+	l.Close()
+	waitPort(t, tmpPort1)
+	if s := server.Sessions(); len(s) != 1 || len(s[0].Remotes) != 1 || len(s[0].Listeners) != 1 {
+		t.Fatalf("expected the session to keep its remote, got %+v", s)
+	}
+}
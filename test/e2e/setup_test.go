@@ -12,10 +12,15 @@ import (
 
 	chclient "github.com/jpillora/chisel/client"
 	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/egress"
 )
 
 const debug = true
 
+// unblockLocal exempts the local endpoints of the tests,
+// such as the fileserver, from the default egress policy
+var unblockLocal = egress.Config{Unblock: []string{"127.0.0.1"}}
+
 // test layout configuration
 type testLayout struct {
 	server     *chserver.Config
@@ -23,6 +28,12 @@ type testLayout struct {
 	fileServer bool
 	udpEcho    bool
 	udpServer  bool
+	filePort   string
+}
+
+// fileAddr is the address of the fileserver
+func (tl *testLayout) fileAddr() string {
+	return "127.0.0.1:" + tl.filePort
 }
 
 func (tl *testLayout) setup(t *testing.T) (server *chserver.Server, client *chclient.Client, teardown func()) {
@@ -32,8 +43,9 @@ func (tl *testLayout) setup(t *testing.T) (server *chserver.Server, client *chcl
 	ctx, cancel := context.WithCancel(context.Background())
 	//fileserver (fake endpoint)
 	filePort := availablePort()
+	tl.filePort = filePort
 	if tl.fileServer {
-		fileAddr := "127.0.0.1:" + filePort
+		fileAddr := tl.fileAddr()
 		f := http.Server{
 			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				b, _ := io.ReadAll(r.Body)
@@ -152,3 +164,19 @@ func availablePort() string {
 	}
 	return port
 }
+
+// waitPort blocks until the given local port accepts
+// connections, useful for listeners bound only once
+// the client has connected (e.g. reverse remotes)
+func waitPort(t *testing.T, port string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		c, err := net.DialTimeout("tcp", "127.0.0.1:"+port, time.Second)
+		if err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("port %s never started listening", port)
+}
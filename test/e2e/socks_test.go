@@ -1,5 +1,67 @@
 package e2e_test
 
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	chclient "github.com/jpillora/chisel/client"
+	chserver "github.com/jpillora/chisel/server"
+	"github.com/jpillora/chisel/share/settings"
+	"golang.org/x/net/proxy"
+)
+
 //TODO tests for:
-// - SOCKS-client -> [client -> server SOCKS] -> endpoint
 // - SOCKS-client -> [server -> client SOCKS] -> endpoint
+
+func TestSocksACL(t *testing.T) {
+	socksPort := availablePort()
+	tl := testLayout{
+		server: &chserver.Config{
+			Egress: unblockLocal,
+			Socks5: true,
+			Users: []*settings.User{{
+				Name:  "foo",
+				Pass:  "bar",
+				Addrs: []*regexp.Regexp{regexp.MustCompile(`^127\.0\.0\.1:\d+$`)},
+			}},
+		},
+		client: &chclient.Config{
+			Auth:    "foo:bar",
+			Remotes: []string{socksPort + ":socks"},
+		},
+		fileServer: true,
+	}
+	_, _, teardown := tl.setup(t)
+	defer teardown()
+	dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:"+socksPort, nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := http.Client{
+		Transport: &http.Transport{
+			Dial: dialer.Dial,
+		},
+	}
+	//SOCKS-client -> [client -> server SOCKS] -> endpoint
+	resp, err := hc.Post("http://"+tl.fileAddr(), "text/plain", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//destinations outside of the user's ACL are refused
+	for _, addr := range []string{"localhost:" + tl.filePort, "127.0.0.2:" + tl.filePort} {
+		if _, err := dialer.Dial("tcp", addr); err == nil {
+			t.Fatalf("expected socks connection to %s to be denied", addr)
+		}
+	}
+}
@@ -19,7 +19,8 @@ func TestTLS(t *testing.T) {
 	//setup server, client, fileserver
 	teardown := simpleSetup(t,
 		&chserver.Config{
-			TLS: *tlsConfig.serverTLS,
+			Egress: unblockLocal,
+			TLS:    *tlsConfig.serverTLS,
 		},
 		&chclient.Config{
 			Remotes: []string{tmpPort + ":$FILEPORT"},
@@ -50,7 +51,8 @@ func TestMTLS(t *testing.T) {
 	//setup server, client, fileserver
 	teardown := simpleSetup(t,
 		&chserver.Config{
-			TLS: *tlsConfig.serverTLS,
+			Egress: unblockLocal,
+			TLS:    *tlsConfig.serverTLS,
 		},
 		&chclient.Config{
 			Remotes: []string{tmpPort + ":$FILEPORT"},